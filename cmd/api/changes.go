@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// listMovieChangesHandler handles the "GET /v1/movies/changes" endpoint. Clients
+// sync incrementally by passing the next_token of each response as the since
+// parameter of the next request, starting without one.
+func (app *application) listMovieChangesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	since := app.readString(qs, "since", "")
+	pageSize := app.readInt(qs, "page_size", 100, v)
+
+	v.Check(pageSize > 0, "page_size", "must be greater than zero")
+	v.Check(pageSize <= 1000, "page_size", "must be a maximum of 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, metadata, err := app.models.Changes.GetSince(since, pageSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidChangeToken):
+			v.AddError("since", "invalid token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"changes": changes, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -9,7 +9,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey          = contextKey("user")
+	runtimeFormatContextKey = contextKey("runtime_format")
+)
 
 // contextSetUser returns a new copy of the request with the provided
 // User struct added to the context.
@@ -28,3 +31,20 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetRuntimeFormat returns a new copy of the request with the format
+// selected for the movie runtimes in the response added to the context.
+func (app *application) contextSetRuntimeFormat(r *http.Request, format data.RuntimeFormat) *http.Request {
+	ctx := context.WithValue(r.Context(), runtimeFormatContextKey, format)
+	return r.WithContext(ctx)
+}
+
+// contextGetRuntimeFormat retrieves the runtime format from the request context.
+// The default format is used if none has been selected.
+func (app *application) contextGetRuntimeFormat(r *http.Request) data.RuntimeFormat {
+	format, ok := r.Context().Value(runtimeFormatContextKey).(data.RuntimeFormat)
+	if !ok {
+		return data.RuntimeFormatDefault
+	}
+	return format
+}
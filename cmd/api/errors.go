@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/patch"
 )
 
 // logError a generic helper for logging an error message.
@@ -54,11 +58,52 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the runtime format requested in the Accept header is not supported"
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %s content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// patchFailedResponse sends a response for a patch document that couldn't be
+// applied: 409 Conflict if a test operation failed, and 422 Unprocessable Entity
+// otherwise.
+func (app *application) patchFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusUnprocessableEntity
+	if errors.Is(err, patch.ErrTestFailed) {
+		status = http.StatusConflict
+	}
+	app.errorResponse(w, r, status, err.Error())
+}
+
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// duplicateMovieResponse sends a 409 Conflict response for a new movie which may
+// be a duplicate, listing the existing movies that it may duplicate.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, candidates []*data.DuplicateCandidate) {
+	env := envelope{
+		"error":      "a movie with the same title and year may already exist, use force=true to create it anyway",
+		"candidates": candidates,
+	}
+
+	err := app.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since the version given in the If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// Export formats.
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatCSV    = "csv"
+	exportFormatJSON   = "json"
+)
+
+// movieWriter writes movies one by one to an export response body.
+type movieWriter interface {
+	// Begin writes anything preceding the first movie.
+	Begin() error
+	// Write writes a single movie.
+	Write(movie *data.Movie) error
+	// End writes anything following the last movie, and flushes the output.
+	End() error
+}
+
+// ndjsonMovieWriter writes movies as newline-delimited JSON, with their runtimes
+// in the format.
+type ndjsonMovieWriter struct {
+	w      *bufio.Writer
+	format data.RuntimeFormat
+}
+
+func (nw *ndjsonMovieWriter) Begin() error { return nil }
+
+func (nw *ndjsonMovieWriter) Write(movie *data.Movie) error {
+	js, err := json.Marshal(movie.WithRuntimeFormat(nw.format))
+	if err != nil {
+		return err
+	}
+	nw.w.Write(js)
+	return nw.w.WriteByte('\n')
+}
+
+func (nw *ndjsonMovieWriter) End() error { return nw.w.Flush() }
+
+// csvMovieWriter writes movies as CSV, in the same format that is accepted by
+// the import endpoint. The localized titles are left out, as the format has no
+// columns for them; the NDJSON and JSON formats include them.
+type csvMovieWriter struct {
+	w *csv.Writer
+}
+
+// The external identifiers are written in a column per namespace, between the
+// genres and version columns.
+func (cw *csvMovieWriter) Begin() error {
+	header := []string{"id", "title", "year", "runtime", "genres"}
+	header = append(header, data.ExternalNamespaces...)
+	return cw.w.Write(append(header, "version"))
+}
+
+func (cw *csvMovieWriter) Write(movie *data.Movie) error {
+	record := []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.Itoa(int(movie.Year)),
+		strconv.Itoa(int(movie.Runtime)),
+		strings.Join(movie.Genres, "|"),
+	}
+	for _, namespace := range data.ExternalNamespaces {
+		record = append(record, movie.ExternalIDs[namespace])
+	}
+	return cw.w.Write(append(record, strconv.Itoa(int(movie.Version))))
+}
+
+func (cw *csvMovieWriter) End() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// jsonMovieWriter writes movies as a JSON array, wrapped in the same envelope
+// as the list endpoint, with their runtimes in the format.
+type jsonMovieWriter struct {
+	w      *bufio.Writer
+	format data.RuntimeFormat
+	count  int
+}
+
+func (jw *jsonMovieWriter) Begin() error {
+	_, err := jw.w.WriteString(`{"movies":[`)
+	return err
+}
+
+func (jw *jsonMovieWriter) Write(movie *data.Movie) error {
+	js, err := json.Marshal(movie.WithRuntimeFormat(jw.format))
+	if err != nil {
+		return err
+	}
+	if jw.count > 0 {
+		jw.w.WriteByte(',')
+	}
+	jw.count++
+	_, err = jw.w.Write(js)
+	return err
+}
+
+func (jw *jsonMovieWriter) End() error {
+	jw.w.WriteString("]}\n")
+	return jw.w.Flush()
+}
+
+// exportMoviesHandler handles the "GET /v1/movies/export" endpoint. Movies are
+// streamed straight from the database to the response body.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieQuery
+		Format string
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.MovieQuery = app.readMovieQuery(qs, v)
+	input.Format = app.readString(qs, "format", exportFormatNDJSON)
+
+	data.ValidateMovieQuery(v, input.MovieQuery)
+	v.Check(validator.In(input.Format, exportFormatNDJSON, exportFormatCSV, exportFormatJSON), "format", "invalid format value")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Genres are matched by their canonical slugs.
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Genres = vocabulary.Normalize(input.Genres)
+	input.GenresAny = vocabulary.Normalize(input.GenresAny)
+
+	// Allow more time than usual for writing the response.
+	err = app.extendDeadlines(w, 5*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var movies movieWriter
+	var contentType string
+
+	switch input.Format {
+	case exportFormatNDJSON:
+		movies = &ndjsonMovieWriter{w: bufio.NewWriter(w), format: app.contextGetRuntimeFormat(r)}
+		contentType = "application/x-ndjson"
+	case exportFormatCSV:
+		movies = &csvMovieWriter{w: csv.NewWriter(w)}
+		contentType = "text/csv"
+	case exportFormatJSON:
+		movies = &jsonMovieWriter{w: bufio.NewWriter(w), format: app.contextGetRuntimeFormat(r)}
+		contentType = "application/json"
+	}
+
+	// The response status and headers are sent with the first movie, so an error
+	// after that point can only be logged, leaving the client with a truncated
+	// response body.
+	started := false
+	begin := func() error {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, input.Format))
+		w.WriteHeader(http.StatusOK)
+		started = true
+		return movies.Begin()
+	}
+
+	err = app.models.Movies.Export(input.MovieQuery, func(movie *data.Movie) error {
+		if !started {
+			err := begin()
+			if err != nil {
+				return err
+			}
+		}
+		return movies.Write(movie)
+	})
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+		} else {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	// An empty export still gets a well-formed response body.
+	if !started {
+		err = begin()
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+
+	err = movies.End()
+	if err != nil {
+		app.logError(r, err)
+	}
+}
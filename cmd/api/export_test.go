@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+)
+
+func TestExportMoviesTitlesAndExternalIDs(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+
+	movie := newTestMovie(t, app, user, "Moana")
+	movie.Titles = []data.MovieTitle{{Language: "en", Title: "Moana", Original: true}, {Language: "sv", Title: "Vaiana"}}
+	movie.ExternalIDs = data.ExternalIDs{"imdb": "tt3521164"}
+	err := app.models.Movies.Update(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestMovie(t, app, user, "Spirited Away")
+
+	for _, format := range []string{exportFormatNDJSON, exportFormatJSON} {
+		rr := serveTest(app, app.exportMoviesHandler, newTestRequest(http.MethodGet, "/v1/movies/export?format="+format, ""), user)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d; want %d", format, rr.Code, http.StatusOK)
+		}
+
+		var movies []data.Movie
+		switch format {
+		case exportFormatNDJSON:
+			scanner := bufio.NewScanner(rr.Body)
+			for scanner.Scan() {
+				var m data.Movie
+				err := json.Unmarshal(scanner.Bytes(), &m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				movies = append(movies, m)
+			}
+		case exportFormatJSON:
+			var response struct {
+				Movies []data.Movie `json:"movies"`
+			}
+			err := json.NewDecoder(rr.Body).Decode(&response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			movies = response.Movies
+		}
+
+		if len(movies) != 2 {
+			t.Fatalf("%s: got %d movies; want 2", format, len(movies))
+		}
+		if got := movies[0].Titles; len(got) != 2 || got[0].Title != "Moana" || !got[0].Original || got[1].Title != "Vaiana" {
+			t.Errorf("%s: got titles %v; want those of the movie", format, got)
+		}
+		if got := movies[0].ExternalIDs; got["imdb"] != "tt3521164" {
+			t.Errorf("%s: got external ids %v; want those of the movie", format, got)
+		}
+		if got := movies[1].Titles; len(got) != 0 {
+			t.Errorf("%s: got titles %v for a movie without any", format, got)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// movieByExternalID serves a movie endpoint for the movie named by the namespace
+// and value URL parameters, such as /v1/movies/by-external/imdb/tt0111161, as if
+// it had been requested by its ID.
+func (app *application) movieByExternalID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		namespace, value := data.NormalizeExternalID(params.ByName("namespace"), params.ByName("value"))
+
+		id, err := app.models.Movies.GetIDForExternalID(namespace, value)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		params = append(params, httprouter.Param{Key: "id", Value: strconv.FormatInt(id, 10)})
+		ctx := context.WithValue(r.Context(), httprouter.ParamsKey, params)
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// checkExternalIDs adds a validation error if any of the external identifiers of
+// the movie belong to another movie, naming the movie they belong to.
+func (app *application) checkExternalIDs(v *validator.Validator, movie *data.Movie) error {
+	owners, err := app.models.Movies.GetExternalIDOwners(movie.ExternalIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, owner := range owners {
+		switch {
+		case owner.MovieID == movie.ID:
+			continue
+		case owner.Trashed:
+			v.AddError("external_ids", fmt.Sprintf("%s id %s belongs to movie %d, which is in the trash", owner.Namespace, owner.Value, owner.MovieID))
+		default:
+			v.AddError("external_ids", fmt.Sprintf("%s id %s already belongs to movie %d", owner.Namespace, owner.Value, owner.MovieID))
+		}
+	}
+
+	return nil
+}
+
+// importClaims records the external identifiers and the existing movies that the
+// rows of an import refer to, each of which may only be referred to by one row.
+type importClaims struct {
+	ids    map[string]int
+	movies map[int64]int
+}
+
+func newImportClaims() *importClaims {
+	return &importClaims{
+		ids:    make(map[string]int),
+		movies: make(map[int64]int),
+	}
+}
+
+// resolveImportedMovie matches an imported movie against the existing movies by
+// its external identifiers. A movie with identifiers that belong to an existing
+// movie updates that movie, and its identifiers are added to those the movie
+// already has. It returns the validation errors of the row, if any.
+func (app *application) resolveImportedMovie(movie *data.Movie, claims *importClaims, row int) (map[string]string, error) {
+	v := validator.New()
+
+	for namespace, value := range movie.ExternalIDs {
+		if n, ok := claims.ids[namespace+":"+value]; ok {
+			v.AddError("external_ids", fmt.Sprintf("%s id %s is already used by row %d", namespace, value, n))
+		}
+	}
+	if !v.Valid() {
+		return v.Errors, nil
+	}
+
+	owners, err := app.models.Movies.GetExternalIDOwners(movie.ExternalIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing *data.Movie
+	for _, owner := range owners {
+		switch {
+		case owner.Trashed:
+			v.AddError("external_ids", fmt.Sprintf("%s id %s belongs to movie %d, which is in the trash", owner.Namespace, owner.Value, owner.MovieID))
+		case owners[0].MovieID != owner.MovieID:
+			v.AddError("external_ids", fmt.Sprintf("must not contain ids of different movies, such as %d and %d", owners[0].MovieID, owner.MovieID))
+		}
+	}
+	if !v.Valid() {
+		return v.Errors, nil
+	}
+
+	if len(owners) > 0 {
+		id := owners[0].MovieID
+		if n, ok := claims.movies[id]; ok {
+			v.AddError("external_ids", fmt.Sprintf("must not refer to movie %d, which is already updated by row %d", id, n))
+			return v.Errors, nil
+		}
+
+		existing, err = app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("external_ids", fmt.Sprintf("must not refer to movie %d, which is in the trash", id))
+				return v.Errors, nil
+			default:
+				return nil, err
+			}
+		}
+
+		for namespace, value := range movie.ExternalIDs {
+			existing.ExternalIDs[namespace] = value
+		}
+		movie.ID = existing.ID
+		movie.Version = existing.Version
+		movie.ExternalIDs = existing.ExternalIDs
+		claims.movies[movie.ID] = row
+	}
+
+	for namespace, value := range movie.ExternalIDs {
+		claims.ids[namespace+":"+value] = row
+	}
+
+	return nil, nil
+}
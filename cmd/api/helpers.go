@@ -7,10 +7,13 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lsjoeberg/greenlight/internal/data"
 	"github.com/lsjoeberg/greenlight/internal/validator"
 )
 
@@ -26,8 +29,146 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readVersionParam retrieves the "version" URL parameter from the current request context.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// readUserIDParam retrieves the "user_id" URL parameter from the current request context.
+func (app *application) readUserIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("user_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid user_id parameter")
+	}
+
+	return id, nil
+}
+
+// readMovieIDParam retrieves the "movie_id" URL parameter from the current request context.
+func (app *application) readMovieIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("movie_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid movie_id parameter")
+	}
+
+	return id, nil
+}
+
+// readCreditIDParam retrieves the "credit_id" URL parameter from the current request context.
+func (app *application) readCreditIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("credit_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid credit_id parameter")
+	}
+
+	return id, nil
+}
+
+// readDeliveryIDParam retrieves the "delivery_id" URL parameter from the current request context.
+func (app *application) readDeliveryIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("delivery_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid delivery_id parameter")
+	}
+
+	return id, nil
+}
+
+// movieETag returns the entity tag for a movie, which is derived from its version
+// and its rating aggregates, which change without a new version, along with the
+// language of the localized title it is shown with, if any.
+func (app *application) movieETag(movie *data.Movie, language string) string {
+	tag := fmt.Sprintf("%d.%d.%s", movie.Version, movie.RatingsCount,
+		strconv.FormatFloat(float64(movie.AverageRating), 'f', -1, 32))
+	if language != "" {
+		tag += "-" + language
+	}
+	return `"` + tag + `"`
+}
+
+// movieIfMatch reports whether the If-Match header of the request, if there is
+// one, matches the current version of the movie, either with its original title
+// or with the localized title for the languages of the request.
+func (app *application) movieIfMatch(r *http.Request, movie *data.Movie) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return true
+	}
+
+	var language string
+	if title, ok := movie.LocalTitle(app.readLanguages(r)); ok {
+		language = title.Language
+	}
+
+	return app.etagMatchStrong(match, app.movieETag(movie, "")) ||
+		app.etagMatchStrong(match, app.movieETag(movie, language))
+}
+
+// etagMatch reports whether the entity tag matches any of the tags listed in an
+// If-None-Match header value, using the weak comparison, which ignores the weak
+// indicator. The value "*" matches any entity tag.
+func (app *application) etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatchStrong reports whether the entity tag matches any of the tags listed
+// in an If-Match header value, using the strong comparison, under which a weak
+// entity tag never matches. The value "*" matches any entity tag.
+func (app *application) etagMatchStrong(header, etag string) bool {
+	if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 type envelope map[string]interface{}
 
+// formatMovie returns the movie for encoding to JSON with its runtime in the
+// format selected for the request.
+func (app *application) formatMovie(r *http.Request, movie *data.Movie) interface{} {
+	return movie.WithRuntimeFormat(app.contextGetRuntimeFormat(r))
+}
+
+// formatMovies returns the movies for encoding to JSON with their runtimes in
+// the format selected for the request.
+func (app *application) formatMovies(r *http.Request, movies []*data.Movie) []interface{} {
+	format := app.contextGetRuntimeFormat(r)
+
+	formatted := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		formatted[i] = movie.WithRuntimeFormat(format)
+	}
+	return formatted
+}
+
 // writeJSON is a helper for sending JSON responses.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error { // Encode the data to JSON, returning the error if there was one.
 	js, err := json.MarshalIndent(data, "", "  ")
@@ -55,8 +196,20 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
+	err := app.decodeJSON(r.Body, dst)
+
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+	}
+	return err
+}
+
+// decodeJSON decodes a single JSON value from body into dst, translating decoding
+// errors into messages that can be sent to the client.
+func (app *application) decodeJSON(body io.Reader, dst interface{}) error {
 	// Return error if body contains unrecognised fields.
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	err := dec.Decode(dst)
@@ -85,9 +238,6 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 
@@ -126,6 +276,53 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// readLanguages returns the languages preferred by the client, in order of
+// preference: either the comma-separated list in the lang query string parameter,
+// or the languages in the Accept-Language header ordered by their quality values.
+// The languages are normalized, and wildcards and excluded languages are left out.
+func (app *application) readLanguages(r *http.Request) []string {
+	languages := app.readCSV(r.URL.Query(), "lang", nil)
+	if languages != nil {
+		for i := range languages {
+			languages[i] = data.NormalizeLanguage(languages[i])
+		}
+		return languages
+	}
+
+	type weighted struct {
+		language string
+		q        float64
+	}
+
+	var ranges []weighted
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		language, params, _ := strings.Cut(part, ";")
+		language = data.NormalizeLanguage(language)
+		if language == "" || language == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{language: language, q: q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		languages = append(languages, r.language)
+	}
+	return languages
+}
+
 // readInt reads a string value from the query string and converts it to an integer
 // before returning. If no matching key could be found it returns the provided
 // default value. If the value couldn't be converted to an integer, then we record an
@@ -146,6 +343,36 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool reads a string value from the query string and converts it to a boolean
+// before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to a boolean, then we record an
+// error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// extendDeadlines extends the server's read and write deadlines for the current
+// request, for endpoints that stream large request or response bodies.
+func (app *application) extendDeadlines(w http.ResponseWriter, d time.Duration) error {
+	rc := http.NewResponseController(w)
+
+	err := rc.SetReadDeadline(time.Now().Add(d))
+	if err != nil {
+		return err
+	}
+	return rc.SetWriteDeadline(time.Now().Add(d))
+}
+
 // background is a helper for running background tasks with panic recovery.
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
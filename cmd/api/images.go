@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/imaging"
+	"github.com/lsjoeberg/greenlight/internal/storage"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// maxImageBytes is the maximum size of an uploaded image.
+const maxImageBytes = 10 * 1_048_576
+
+// maxImageDimension is the maximum width and height of an uploaded image, which
+// limits the memory needed to decode it to 64MB.
+const maxImageDimension = 4000
+
+// imageSpec holds the minimum dimensions and the thumbnail bounds of a kind of
+// image.
+type imageSpec struct {
+	minWidth, minHeight     int
+	thumbWidth, thumbHeight int
+}
+
+var imageSpecs = map[string]imageSpec{
+	data.ImagePoster:   {minWidth: 200, minHeight: 300, thumbWidth: 185, thumbHeight: 278},
+	data.ImageBackdrop: {minWidth: 640, minHeight: 360, thumbWidth: 300, thumbHeight: 169},
+}
+
+// readImagePart reads the contents of the "image" part of a multipart request
+// body. Any other parts are ignored.
+func (app *application) readImagePart(mr *multipart.Reader) ([]byte, error) {
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.Is(err, io.EOF):
+				return nil, errors.New(`body must contain an "image" part`)
+			case errors.As(err, &maxBytesError):
+				return nil, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			default:
+				return nil, err
+			}
+		}
+
+		if part.FormName() != "image" {
+			continue
+		}
+
+		content, err := io.ReadAll(io.LimitReader(part, maxImageBytes+1))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+			return nil, err
+		}
+		if len(content) > maxImageBytes {
+			return nil, fmt.Errorf("image must not be larger than %d bytes", maxImageBytes)
+		}
+
+		return content, nil
+	}
+}
+
+// putMovieImageHandler returns the handler for the "PUT /v1/movies/:id/<kind>"
+// endpoint. The image is uploaded as the "image" part of a multipart/form-data
+// body, and replaces any existing image of the same kind.
+func (app *application) putMovieImageHandler(kind string) http.HandlerFunc {
+	spec := imageSpecs[kind]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		_, err = app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Leave some room for the multipart headers and boundaries in the body
+		// limit, and allow more time than usual for reading it.
+		r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes+1_048_576)
+
+		err = app.extendDeadlines(w, time.Minute)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			if errors.Is(err, http.ErrNotMultipart) {
+				app.unsupportedMediaTypeResponse(w, r)
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		content, err := app.readImagePart(mr)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Check the actual type of the content, rather than trusting the client,
+		// and the dimensions before decoding the whole image.
+		v := validator.New()
+
+		contentType := http.DetectContentType(content)
+		if v.Check(validator.In(contentType, "image/jpeg", "image/png"), "image", "must be a JPEG or PNG image"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(content))
+		if err != nil {
+			v.AddError("image", "must be a valid image")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		v.Check(config.Width >= spec.minWidth && config.Height >= spec.minHeight, "image",
+			fmt.Sprintf("must be at least %dx%d pixels", spec.minWidth, spec.minHeight))
+		v.Check(config.Width <= maxImageDimension && config.Height <= maxImageDimension, "image",
+			fmt.Sprintf("must not be larger than %dx%d pixels", maxImageDimension, maxImageDimension))
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(content))
+		if err != nil {
+			v.AddError("image", "must be a valid image")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		var thumbnail bytes.Buffer
+		err = jpeg.Encode(&thumbnail, imaging.Fit(img, spec.thumbWidth, spec.thumbHeight), &jpeg.Options{Quality: 85})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		checksum := sha256.Sum256(content)
+
+		movieImage := &data.MovieImage{
+			MovieID:     id,
+			Kind:        kind,
+			ContentType: contentType,
+			Width:       int32(config.Width),
+			Height:      int32(config.Height),
+			Size:        int64(len(content)),
+			Checksum:    hex.EncodeToString(checksum[:]),
+		}
+
+		previous, err := app.models.Images.Get(id, kind)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Store the files before the metadata, so that the metadata never refers
+		// to missing files.
+		err = app.storage.Put(movieImage.Key(), bytes.NewReader(content))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.storage.Put(movieImage.ThumbnailKey(), &thumbnail)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Images.Save(movieImage)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if previous != nil && previous.Checksum != movieImage.Checksum {
+			app.deleteImageFiles(r, previous)
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"image": movieImage}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// showMovieImageHandler returns the handler for the "GET /v1/movies/:id/<kind>"
+// endpoint. The thumbnail is served instead of the image when the size parameter
+// is "thumbnail". Images are cached by clients, and revalidated by their ETag.
+func (app *application) showMovieImageHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		size := app.readString(r.URL.Query(), "size", "original")
+		if v.Check(validator.In(size, "original", "thumbnail"), "size", "invalid size value"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		movieImage, err := app.models.Images.Get(id, kind)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		key, contentType, etag := movieImage.Key(), movieImage.ContentType, `"`+movieImage.Checksum+`"`
+		if size == "thumbnail" {
+			key, contentType, etag = movieImage.ThumbnailKey(), "image/jpeg", `"`+movieImage.Checksum+`-thumbnail"`
+		}
+
+		f, err := app.storage.Open(key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer f.Close()
+
+		// ServeContent handles the conditional and range request headers. The image
+		// URL stays the same when the image is replaced, so clients must revalidate
+		// their cached copy with the entity tag.
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", movieImage.CreatedAt, f)
+	}
+}
+
+// deleteMovieImageHandler returns the handler for the "DELETE /v1/movies/:id/<kind>"
+// endpoint.
+func (app *application) deleteMovieImageHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		movieImage, err := app.models.Images.Get(id, kind)
+		if err == nil {
+			err = app.models.Images.Delete(id, kind)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.deleteImageFiles(r, movieImage)
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": kind + " successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// deleteImageFiles removes the files of an image which is no longer referenced.
+// Errors are only logged, as the metadata has already been updated.
+func (app *application) deleteImageFiles(r *http.Request, movieImage *data.MovieImage) {
+	for _, key := range []string{movieImage.Key(), movieImage.ThumbnailKey()} {
+		err := app.storage.Delete(key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			app.logError(r, err)
+		}
+	}
+}
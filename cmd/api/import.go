@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// Import modes: an atomic import is rolled back if any row is invalid, while a
+// continue import inserts the valid rows and skips the invalid ones.
+const (
+	importModeAtomic   = "atomic"
+	importModeContinue = "continue"
+)
+
+// importBatchSize is the number of movies inserted per batch.
+const importBatchSize = 100
+
+// errInvalidRow wraps errors for rows that couldn't be parsed; reading may
+// continue with the next row.
+var errInvalidRow = errors.New("invalid row")
+
+// movieReader reads movies row by row from an import request body.
+type movieReader interface {
+	// Read returns the next movie, or io.EOF when there are no more rows. Errors
+	// wrapping errInvalidRow apply only to the current row.
+	Read() (*data.Movie, error)
+}
+
+// ndjsonMovieReader reads movies from newline-delimited JSON, one movie object
+// per line. Blank lines are skipped.
+type ndjsonMovieReader struct {
+	scanner *bufio.Scanner
+}
+
+func newNDJSONMovieReader(r io.Reader) *ndjsonMovieReader {
+	scanner := bufio.NewScanner(r)
+	// Allow lines up to the same size as a regular JSON request body.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+	return &ndjsonMovieReader{scanner: scanner}
+}
+
+func (nr *ndjsonMovieReader) Read() (*data.Movie, error) {
+	for nr.scanner.Scan() {
+		line := bytes.TrimSpace(nr.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title       string           `json:"title"`
+			ExternalIDs data.ExternalIDs `json:"external_ids"`
+			Year        int32            `json:"year"`
+			Runtime     data.Runtime     `json:"runtime"`
+			Genres      []string         `json:"genres"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err != nil {
+			return nil, fmt.Errorf("%w: contains badly-formed JSON", errInvalidRow)
+		}
+
+		movie := &data.Movie{
+			Title:       input.Title,
+			ExternalIDs: input.ExternalIDs,
+			Year:        input.Year,
+			Runtime:     input.Runtime,
+			Genres:      input.Genres,
+		}
+		return movie, nil
+	}
+
+	if err := nr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// csvMovieReader reads movies from CSV with a header row naming the title,
+// year, runtime and genres columns, in the format written by the export
+// endpoint. The runtime is given in minutes, and genres are separated by "|".
+// The external identifiers are read from optional columns named after their
+// namespaces, such as imdb, which may be left empty.
+type csvMovieReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVMovieReader(r io.Reader) (*csvMovieReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, err
+	}
+
+	// The id and version columns written by the export endpoint are ignored.
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if validator.In(name, "id", "version") {
+			continue
+		}
+		if !validator.In(name, "title", "year", "runtime", "genres") && !validator.In(name, data.ExternalNamespaces...) {
+			return nil, fmt.Errorf("body contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("body is missing the %q column", name)
+		}
+	}
+
+	return &csvMovieReader{reader: reader, columns: columns}, nil
+}
+
+func (cr *csvMovieReader) Read() (*data.Movie, error) {
+	record, err := cr.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return nil, fmt.Errorf("%w: %s", errInvalidRow, parseError.Err)
+		}
+		return nil, err
+	}
+
+	movie := &data.Movie{
+		Title: record[cr.columns["title"]],
+	}
+
+	year, err := strconv.ParseInt(record[cr.columns["year"]], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: year must be an integer value", errInvalidRow)
+	}
+	movie.Year = int32(year)
+
+	movie.Runtime, err = data.ParseRuntime(record[cr.columns["runtime"]])
+	if err != nil {
+		return nil, fmt.Errorf("%w: runtime must be a number of minutes or a duration", errInvalidRow)
+	}
+
+	movie.Genres = []string{}
+	if genres := record[cr.columns["genres"]]; genres != "" {
+		movie.Genres = strings.Split(genres, "|")
+	}
+
+	movie.ExternalIDs = data.ExternalIDs{}
+	for _, namespace := range data.ExternalNamespaces {
+		if i, ok := cr.columns[namespace]; ok && record[i] != "" {
+			movie.ExternalIDs[namespace] = record[i]
+		}
+	}
+
+	return movie, nil
+}
+
+// Import row actions: a row either creates a new movie, or updates the existing
+// movie that its external identifiers belong to.
+const (
+	importActionCreated = "created"
+	importActionUpdated = "updated"
+)
+
+// importRow holds the outcome of importing a single row.
+type importRow struct {
+	Row    int               `json:"row"`
+	ID     int64             `json:"id,omitempty"`
+	Action string            `json:"action,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importMoviesHandler handles the "POST /v1/movies/import" endpoint. The
+// request body is streamed as NDJSON or CSV, depending on the Content-Type
+// header. Rows with external identifiers that belong to an existing movie
+// update that movie instead of creating a new one.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	mode := app.readString(r.URL.Query(), "mode", importModeAtomic)
+	if v.Check(validator.In(mode, importModeAtomic, importModeContinue), "mode", "invalid mode value"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Limit the size of the request body to 100MB, and allow more time than usual
+	// for reading it.
+	maxBytes := 100 * 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	err := app.extendDeadlines(w, 5*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var movies movieReader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson":
+		movies = newNDJSONMovieReader(r.Body)
+	case "text/csv":
+		movies, err = newCSVMovieReader(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	// The genres of every row are normalized to their canonical slugs.
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	rows := []importRow{}
+	claims := newImportClaims()
+
+	var (
+		batch     []*data.Movie
+		indices   []int
+		inserted  []*data.Movie
+		updated   []*data.Movie
+		failed    int
+		committed bool
+		imp       *data.MovieImport
+	)
+
+	// Roll back any transaction which hasn't been committed when the handler returns.
+	defer func() {
+		if imp != nil {
+			imp.Rollback()
+		}
+	}()
+
+	// The events of the created and updated movies are queued with them, and are
+	// sent once committed, even if the import is cut short by a later error.
+	defer func() {
+		if committed {
+			app.wakeWebhooks()
+		}
+	}()
+
+	// save saves movies in the import transaction, beginning one if needed. In
+	// continue mode the transaction is committed right away, or rolled back if
+	// the movies couldn't be saved.
+	save := func(movies []*data.Movie) error {
+		var err error
+		if imp == nil {
+			imp, err = app.models.Movies.BeginImport(userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = imp.Save(movies)
+		if mode == importModeAtomic {
+			return err
+		}
+		if err != nil {
+			imp.Rollback()
+			imp = nil
+			return err
+		}
+
+		err = imp.Commit()
+		imp = nil
+		if err != nil {
+			return err
+		}
+		committed = true
+		return nil
+	}
+
+	// saved records the outcome of the row of the i-th movie of the batch.
+	saved := func(i int) {
+		movie := batch[i]
+		row := &rows[indices[i]]
+		row.ID = movie.ID
+		if row.Action == importActionCreated {
+			inserted = append(inserted, movie)
+		} else {
+			updated = append(updated, movie)
+		}
+	}
+
+	// flush saves the current batch of movies. In atomic mode all batches share
+	// one transaction, which is committed at the end; in continue mode each batch
+	// is committed on its own.
+	flush := func() error {
+		defer func() {
+			batch = batch[:0]
+			indices = indices[:0]
+		}()
+
+		// There is no point in saving rows that will be rolled back.
+		if len(batch) == 0 || (mode == importModeAtomic && failed > 0) {
+			return nil
+		}
+
+		// Saving sets the IDs and versions of the movies, which have to be reset
+		// if the batch is rolled back.
+		originals := make([]data.Movie, len(batch))
+		for i, movie := range batch {
+			originals[i] = *movie
+		}
+
+		err := save(batch)
+		if err == nil {
+			for i := range batch {
+				saved(i)
+			}
+			return nil
+		}
+		if mode == importModeAtomic || !isImportConflict(err) {
+			return err
+		}
+
+		// Another request changed one of the movies, or took one of the external
+		// identifiers, since the rows were read. The rows are saved one at a time,
+		// so that only the conflicting ones fail.
+		for i, movie := range batch {
+			*movie = originals[i]
+
+			err := save([]*data.Movie{movie})
+			if err != nil {
+				if !isImportConflict(err) {
+					return err
+				}
+				row := &rows[indices[i]]
+				row.Action = ""
+				row.Errors = importConflictErrors(err)
+				failed++
+				continue
+			}
+			saved(i)
+		}
+
+		return nil
+	}
+
+	for n := 1; ; n++ {
+		movie, err := movies.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		row := importRow{Row: n}
+
+		switch {
+		case errors.Is(err, errInvalidRow):
+			row.Errors = map[string]string{"row": strings.TrimPrefix(err.Error(), errInvalidRow.Error()+": ")}
+		case err != nil:
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytes))
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		default:
+			movie.Genres = vocabulary.Normalize(movie.Genres)
+			movie.ExternalIDs = data.NormalizeExternalIDs(movie.ExternalIDs)
+
+			v := validator.New()
+			if data.ValidateMovie(v, movie, vocabulary); !v.Valid() {
+				row.Errors = v.Errors
+				break
+			}
+
+			row.Errors, err = app.resolveImportedMovie(movie, claims, n)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			switch {
+			case row.Errors != nil:
+			case movie.ID != 0:
+				row.Action = importActionUpdated
+			default:
+				row.Action = importActionCreated
+			}
+		}
+
+		rows = append(rows, row)
+
+		if row.Errors != nil {
+			failed++
+			continue
+		}
+
+		batch = append(batch, movie)
+		indices = append(indices, len(rows)-1)
+
+		if len(batch) == importBatchSize {
+			err = flush()
+			if err != nil {
+				app.importFailedResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	err = flush()
+	if err != nil {
+		app.importFailedResponse(w, r, err)
+		return
+	}
+
+	// An atomic import is only committed if every row was valid.
+	status := http.StatusOK
+	if mode == importModeAtomic {
+		if failed > 0 {
+			status = http.StatusUnprocessableEntity
+			inserted, updated = nil, nil
+			for i := range rows {
+				rows[i].ID = 0
+				rows[i].Action = ""
+			}
+		} else if imp != nil {
+			err = imp.Commit()
+			imp = nil
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			committed = true
+		}
+	}
+
+	report := envelope{
+		"mode":    mode,
+		"created": len(inserted),
+		"updated": len(updated),
+		"failed":  failed,
+		"rows":    rows,
+	}
+
+	err = app.writeJSON(w, status, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// isImportConflict reports whether a batch couldn't be saved because an existing
+// movie was changed, or an external identifier was taken, since its row was read.
+func isImportConflict(err error) bool {
+	return errors.Is(err, data.ErrEditConflict) || errors.Is(err, data.ErrDuplicateExternalID)
+}
+
+// importConflictErrors returns the errors of a row that couldn't be saved in
+// continue mode because of a conflict with another request.
+func importConflictErrors(err error) map[string]string {
+	if errors.Is(err, data.ErrDuplicateExternalID) {
+		return map[string]string{"external_ids": "must not contain ids that belong to another movie"}
+	}
+	return map[string]string{"row": "the movie was changed by another request during the import"}
+}
+
+// importFailedResponse sends the response for an import batch that couldn't be
+// saved. In atomic mode, a conflict with another request is reported as an edit
+// conflict, as the whole import is rolled back.
+func (app *application) importFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case isImportConflict(err):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
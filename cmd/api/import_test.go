@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// lineReader serves the lines of a request body one line per read at most,
+// calling before with the number of each line before it's served, once the
+// previous lines have been read.
+type lineReader struct {
+	lines  []string
+	n      int
+	rest   string
+	before func(n int)
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	if lr.rest == "" {
+		if lr.n == len(lr.lines) {
+			return 0, io.EOF
+		}
+		lr.n++
+		if lr.before != nil {
+			lr.before(lr.n)
+		}
+		lr.rest = lr.lines[lr.n-1]
+	}
+
+	n := copy(p, lr.rest)
+	lr.rest = lr.rest[n:]
+	return n, nil
+}
+
+func TestImportMoviesContinuesAfterConflictInLaterBatch(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+
+	existing := newTestMovie(t, app, user, "Amélie")
+	existing.ExternalIDs = map[string]string{"imdb": "tt0211915"}
+	err := app.models.Movies.Update(existing, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second batch updates the existing movie in one of its rows, and creates
+	// new movies in the others.
+	const rows, conflictRow = 2 * importBatchSize, importBatchSize + 50
+
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"title": "Movie %d", "year": 2001, "runtime": 100, "genres": ["drama"]}`+"\n", i+1)
+	}
+	lines[conflictRow-1] = `{"title": "Le Fabuleux Destin d'Amélie Poulain", "year": 2001, "runtime": 122, "genres": ["comedy"], "external_ids": {"imdb": "tt0211915"}}` + "\n"
+
+	// Another request changes the existing movie after its row has been read, but
+	// before the batch is saved.
+	body := &lineReader{lines: lines, before: func(n int) {
+		if n != conflictRow+1 {
+			return
+		}
+		movie, err := app.models.Movies.Get(existing.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		movie.Title = "Amelie"
+		err = app.models.Movies.Update(movie, user.ID)
+		if err != nil {
+			t.Error(err)
+		}
+	}}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import?mode=continue", body)
+	r.Header.Set("Content-Type", "application/x-ndjson")
+
+	rr := serveTest(app, app.importMoviesHandler, r, user)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var response struct {
+		Report struct {
+			Created int         `json:"created"`
+			Updated int         `json:"updated"`
+			Failed  int         `json:"failed"`
+			Rows    []importRow `json:"rows"`
+		} `json:"report"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := response.Report
+
+	if report.Created != rows-1 || report.Updated != 0 || report.Failed != 1 {
+		t.Errorf("got %d created, %d updated and %d failed; want %d, 0 and 1", report.Created, report.Updated, report.Failed, rows-1)
+	}
+	if len(report.Rows) != rows {
+		t.Fatalf("got %d rows; want %d", len(report.Rows), rows)
+	}
+
+	for i, row := range report.Rows {
+		switch {
+		case row.Row == conflictRow:
+			if row.Errors == nil || row.Action != "" || row.ID != 0 {
+				t.Errorf("got row %d %+v; want it to fail", row.Row, row)
+			}
+		case row.Errors != nil || row.Action != importActionCreated || row.ID == 0:
+			t.Errorf("got row %d %+v; want it to be created", row.Row, row)
+		}
+		if row.Row != i+1 {
+			t.Errorf("got row %d at index %d", row.Row, i)
+		}
+	}
+
+	movie, err := app.models.Movies.Get(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "Amelie" {
+		t.Errorf("got title %q for the existing movie; want the concurrent change %q", movie.Title, "Amelie")
+	}
+
+	select {
+	case <-app.webhooksWake:
+	default:
+		t.Error("the webhook delivery worker wasn't woken")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+)
+
+// runWorker runs the job in a background goroutine, once at startup and then on
+// every tick of the interval, or as soon as it's woken up on the wake channel,
+// which may be nil. The worker stops when the application shuts down, and the
+// shutdown waits for a run in progress to complete. A panic in a run is logged,
+// and doesn't stop the worker.
+func (app *application) runWorker(interval time.Duration, wake <-chan struct{}, job func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						app.logger.PrintError(fmt.Errorf("%s", err), nil)
+					}
+				}()
+				job()
+			}()
+
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+// startWorkers starts the periodic background jobs that are enabled.
+func (app *application) startWorkers() {
+	if app.config.trash.retention > 0 {
+		app.runWorker(time.Hour, nil, app.purgeTrashedMovies)
+	}
+
+	// Deliveries that are due to be retried are checked for every few seconds.
+	app.runWorker(5*time.Second, app.webhooksWake, app.deliverWebhooks)
+
+	if app.config.searches.interval > 0 {
+		app.runWorker(app.config.searches.interval, nil, app.sendSavedSearchDigests)
+	}
+}
+
+// purgeTrashedMovies removes the movies that have been in the trash for longer
+// than the configured retention period, along with their images.
+func (app *application) purgeTrashedMovies() {
+	ids, err := app.models.Movies.PurgeTrashed(app.config.trash.retention)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	} else if len(ids) > 0 {
+		app.logger.PrintInfo("purged trashed movies", map[string]string{
+			"count": strconv.Itoa(len(ids)),
+		})
+	}
+
+	// The image metadata is removed with the movies, but the files have to
+	// be removed from storage separately.
+	for _, id := range ids {
+		err := app.storage.DeleteAll(data.MovieImagesPrefix(id))
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"movie_id": strconv.FormatInt(id, 10),
+			})
+		}
+	}
+}
+
+// deliverWebhooks sends the pending webhook deliveries that are due, in batches
+// until there are none left or the application shuts down.
+func (app *application) deliverWebhooks() {
+	const batchSize = 20
+
+	// The deliveries of a batch are sent concurrently, so the lease only has to
+	// outlast a single attempt.
+	lease := app.config.webhooks.timeout + time.Minute
+
+	for {
+		deliveries, err := app.models.Deliveries.Claim(batchSize, lease)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery *data.PendingDelivery) {
+				defer wg.Done()
+				app.deliverWebhook(delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		// A full batch means that there may be more deliveries that are due.
+		if len(deliveries) < batchSize {
+			return
+		}
+
+		select {
+		case <-app.shutdown:
+			return
+		default:
+		}
+	}
+}
+
+// sendSavedSearchDigests emails the owners of saved searches with notifications
+// turned on a digest of the movies created since the last run that match their
+// searches, for the changes made up to the latest one.
+func (app *application) sendSavedSearchDigests() {
+	latest, err := app.models.Changes.Latest()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	searches, err := app.models.Searches.GetAllDue(latest)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	sent := 0
+	for _, search := range searches {
+		ok, err := app.sendSavedSearchDigest(search, latest)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"saved_search_id": strconv.FormatInt(search.ID, 10),
+			})
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+
+	if sent > 0 {
+		app.logger.PrintInfo("queued saved search digests", map[string]string{
+			"count": strconv.Itoa(sent),
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// errListNotPermitted is returned when a user tries to modify a public list that
+// they don't own.
+var errListNotPermitted = errors.New("list not permitted")
+
+// readList fetches the list named by the "id" URL parameter, and checks that the
+// current user may read it, or modify it if write is set. Lists can be read by
+// their owner, and by anyone if they are public, but only modified by their owner.
+// Private lists of other users are reported as not found.
+func (app *application) readList(r *http.Request, write bool) (*data.List, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	list, err := app.models.Lists.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user := app.contextGetUser(r)
+	switch {
+	case list.UserID == user.ID:
+		return list, nil
+	case !list.Public:
+		return nil, data.ErrRecordNotFound
+	case write:
+		return nil, errListNotPermitted
+	}
+
+	return list, nil
+}
+
+// listErrorResponse sends the response for an error returned by readList or the
+// ListModel methods.
+func (app *application) listErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, errListNotPermitted):
+		app.notPermittedResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createListHandler handles the "POST /v1/lists" endpoint.
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.List{
+		UserID:      app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: input.Description,
+		Public:      input.Public,
+	}
+
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Insert(list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d", list.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listListsHandler handles the "GET /v1/lists" endpoint, which lists the lists
+// owned by the current user.
+func (app *application) listListsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+	input.Filters.Total = data.TotalExact
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lists, metadata, err := app.models.Lists.GetAllForUser(app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lists": lists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showWatchlistHandler handles the "GET /v1/lists/watchlist" endpoint. The
+// watchlist of the current user is created the first time it's requested.
+func (app *application) showWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.models.Lists.GetWatchlist(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showListHandler handles the "GET /v1/lists/:id" endpoint.
+func (app *application) showListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, false)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateListHandler handles the "PATCH /v1/lists/:id" endpoint.
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, true)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.Description != nil {
+		list.Description = *input.Description
+	}
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+
+	v := validator.New()
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Update(list)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteListHandler handles the "DELETE /v1/lists/:id" endpoint. The watchlist
+// can't be deleted.
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, true)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	if list.Watchlist {
+		v := validator.New()
+		v.AddError("list", "the watchlist can't be deleted")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.Delete(list.ID)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listListEntriesHandler handles the "GET /v1/lists/:id/entries" endpoint.
+func (app *application) listListEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, false)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "position")
+	input.Filters.SortSafelist = []string{"position", "added_at", "-position", "-added_at"}
+	input.Filters.Total = data.TotalExact
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Lists.GetEntries(list.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entries": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addListEntryHandler handles the "POST /v1/lists/:id/entries" endpoint.
+func (app *application) addListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, true)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		MovieID  int64  `json:"movie_id"`
+		Position int32  `json:"position"`
+		Note     string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entry := &data.ListEntry{
+		ListID:   list.ID,
+		MovieID:  input.MovieID,
+		Position: input.Position,
+		Note:     input.Note,
+	}
+
+	v := validator.New()
+	if data.ValidateListEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.AddEntry(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "movie does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateListEntry):
+			v.AddError("movie_id", "movie is already in the list")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d/entries/%d", list.ID, entry.MovieID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateListEntryHandler handles the "PATCH /v1/lists/:id/entries/:movie_id"
+// endpoint, which changes the note of an entry or moves it to a new position.
+func (app *application) updateListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, true)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	movieID, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	entry, err := app.models.Lists.GetEntry(list.ID, movieID)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Position *int32  `json:"position"`
+		Note     *string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Position != nil {
+		entry.Position = *input.Position
+	}
+	if input.Note != nil {
+		entry.Note = *input.Note
+	}
+
+	v := validator.New()
+	if data.ValidateListEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Lists.UpdateEntry(entry)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeListEntryHandler handles the "DELETE /v1/lists/:id/entries/:movie_id" endpoint.
+func (app *application) removeListEntryHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := app.readList(r, true)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	movieID, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Lists.RemoveEntry(list.ID, movieID)
+	if err != nil {
+		app.listErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from list"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
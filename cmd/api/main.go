@@ -12,10 +12,12 @@ import (
 	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/lsjoeberg/greenlight/internal/data"
 	"github.com/lsjoeberg/greenlight/internal/jsonlog"
 	"github.com/lsjoeberg/greenlight/internal/mailer"
+	"github.com/lsjoeberg/greenlight/internal/storage"
+	"github.com/lsjoeberg/greenlight/internal/webhook"
 )
 
 var (
@@ -28,9 +30,10 @@ var (
 
 // config holds application configuration.
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port    int
+	env     string
+	baseURL string
+	db      struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
@@ -51,15 +54,37 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	trash struct {
+		retention time.Duration
+	}
+	storage struct {
+		dir string
+	}
+	webhooks struct {
+		timeout     time.Duration
+		maxAttempts int
+	}
+	searches struct {
+		interval time.Duration
+	}
 }
 
 // application holds application dependencies.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	// webhooks sends webhook deliveries, and webhooksWake wakes the delivery
+	// worker when new deliveries are queued.
+	webhooks     webhook.Client
+	webhooksWake chan struct{}
+	movieStream  *movieStream
+	// shutdown is closed when the server shuts down, to stop the background
+	// workers.
+	shutdown chan struct{}
+	wg       sync.WaitGroup
 }
 
 func main() {
@@ -69,6 +94,7 @@ func main() {
 	// Server config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.baseURL, "base-url", "http://localhost:4000", "Public base URL of the API, used for links in emails")
 
 	// Database config
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
@@ -94,6 +120,19 @@ func main() {
 		return nil
 	})
 
+	// Trash config.
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Time before trashed movies are purged (0 disables purging)")
+
+	// Storage config.
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./storage", "Directory for uploaded images")
+
+	// Webhooks config.
+	flag.DurationVar(&cfg.webhooks.timeout, "webhooks-timeout", 10*time.Second, "Timeout for each webhook delivery attempt")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhooks-max-attempts", 8, "Maximum attempts of a webhook delivery before it fails")
+
+	// Saved searches config.
+	flag.DurationVar(&cfg.searches.interval, "searches-interval", time.Hour, "Time between matching saved searches against new movies (0 disables notifications)")
+
 	// Version.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -116,6 +155,12 @@ func main() {
 	defer db.Close()
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Create the storage backend for uploaded images.
+	store, err := storage.NewLocal(cfg.storage.dir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// Publish version in the expvar handler containing our application.
 	expvar.NewString("version").Set(version)
 
@@ -135,12 +180,32 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+
+		webhooks:     webhook.New(cfg.webhooks.timeout),
+		webhooksWake: make(chan struct{}, 1),
+		movieStream:  newMovieStream(),
+		shutdown:     make(chan struct{}),
 	}
 
+	// Listen for the changes to movies notified by the database, which are sent
+	// to the clients of the movie stream.
+	listener := pq.NewListener(cfg.db.dsn, 10*time.Second, time.Minute, nil)
+	err = listener.Listen(data.MovieChangesChannel)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer listener.Close()
+
+	go app.listenForMovieChanges(listener)
+
+	// Start the periodic background jobs.
+	app.startWorkers()
+
 	// Start the HTTP server.
 	err = app.serve()
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
@@ -230,13 +231,16 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					// out of the loop.
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
+					// Allow scripts to read the ETag header used for conditional requests.
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
 					// Check if the request has the HTTP method OPTIONS and contains the
 					// "Access-Control-Request-Method" header. If it does, then we treat
 					// it as a preflight request.
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						// Set the necessary preflight response headers.
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
 						w.WriteHeader(http.StatusOK)
 						return
 					}
@@ -248,6 +252,46 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// negotiateRuntimeFormat selects the format of the movie runtimes in the response,
+// from the runtime_format query string parameter, or else from a runtime_format
+// parameter of a JSON media range in the Accept header. The format is added to
+// the request context for the movie endpoints to encode their movies with.
+func (app *application) negotiateRuntimeFormat(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		format := r.URL.Query().Get("runtime_format")
+		if format != "" {
+			v := validator.New()
+			if v.Check(validator.In(format, data.RuntimeFormatSafelist...), "runtime_format", "invalid runtime format value"); !v.Valid() {
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			}
+		} else {
+			for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+				mediaType, params, err := mime.ParseMediaType(mediaRange)
+				if err != nil || !validator.In(mediaType, "application/json", "application/*", "*/*") {
+					continue
+				}
+				if value, ok := params["runtime_format"]; ok {
+					if !validator.In(value, data.RuntimeFormatSafelist...) {
+						app.notAcceptableResponse(w, r)
+						return
+					}
+					format = value
+					break
+				}
+			}
+		}
+
+		if format != "" {
+			r = app.contextSetRuntimeFormat(r, data.RuntimeFormat(format))
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	// Initialize the new expvar variables when the middleware chain is first built.
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
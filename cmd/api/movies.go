@@ -1,21 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/patch"
 	"github.com/lsjoeberg/greenlight/internal/validator"
 )
 
 // createMovieHandler handles the "POST /v1/movies" endpoint.
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title       string            `json:"title"`
+		Titles      []data.MovieTitle `json:"titles"`
+		ExternalIDs data.ExternalIDs  `json:"external_ids"`
+		Year        int32             `json:"year"`
+		Runtime     data.Runtime      `json:"runtime"`
+		Genres      []string          `json:"genres"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -26,32 +34,78 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Copy input to a Movie struct.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:       input.Title,
+		Titles:      input.Titles,
+		ExternalIDs: data.NormalizeExternalIDs(input.ExternalIDs),
+		Year:        input.Year,
+		Runtime:     input.Runtime,
+		Genres:      input.Genres,
 	}
+	data.NormalizeTitles(movie.Titles)
 
-	// Validate inputs.
+	// Normalize the genres to their canonical slugs.
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = vocabulary.Normalize(movie.Genres)
+
+	// Validate inputs. Duplicates are only allowed when explicitly forced.
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+
+	force := app.readBool(r.URL.Query(), "force", false, v)
+
+	if data.ValidateMovie(v, movie, vocabulary); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Insert new db movie record.
-	err = app.models.Movies.Insert(movie)
+	// External identifiers must be unique, even when duplicates are forced.
+	err = app.checkExternalIDs(v, movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !force {
+		candidates, err := app.models.Movies.FindDuplicates(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if len(candidates) > 0 {
+			app.duplicateMovieResponse(w, r, candidates)
+			return
+		}
+	}
+
+	// Insert new db movie record.
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "must not contain ids that belong to another movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeWebhooks()
 
 	// Include a Location header to let the client know which URL they can
 	// find the newly-created resource at.
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", app.movieETag(movie, ""))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": app.formatMovie(r, movie)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -78,8 +132,26 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Show the title in the language preferred by the client.
+	language := movie.Localize(app.readLanguages(r))
+	w.Header().Add("Vary", "Accept-Language")
+
+	// If the client already has the current version of the movie in the same
+	// language, send a 304 Not Modified response without a body.
+	etag := app.movieETag(movie, language)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && app.etagMatch(match, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+
 	// Encode the struct to JSON and send it as the HTTP response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.formatMovie(r, movie)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -105,19 +177,86 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// If the client sent an If-Match header, only update the movie if it's still
+	// at the version the client has.
+	if !app.movieIfMatch(r, movie) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	// Declare an input struct to hold the expected data from the client.
 	// Use pointer type fields, such that zero value is always nil.
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Title       *string           `json:"title"`
+		Titles      []data.MovieTitle `json:"titles"`
+		ExternalIDs data.ExternalIDs  `json:"external_ids"`
+		Year        *int32            `json:"year"`
+		Runtime     *data.Runtime     `json:"runtime"`
+		Genres      []string          `json:"genres"`
 	}
 
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// The request body is either a set of fields to update, or a patch document
+	// that is applied to the movie, depending on its media type.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "", "application/json":
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+	case "application/merge-patch+json", "application/json-patch+json":
+		var patchDocument json.RawMessage
+		err = app.readJSON(w, r, &patchDocument)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Apply the patch to a document holding the editable movie fields.
+		document, err := json.Marshal(map[string]interface{}{
+			"title":        movie.Title,
+			"titles":       movie.Titles,
+			"external_ids": movie.ExternalIDs,
+			"year":         movie.Year,
+			"runtime":      movie.Runtime,
+			"genres":       movie.Genres,
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		var patched []byte
+		if mediaType == "application/merge-patch+json" {
+			patched, err = patch.Merge(document, patchDocument)
+		} else {
+			patched, err = patch.Apply(document, patchDocument)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, patch.ErrInvalidPatch):
+				app.badRequestResponse(w, r, err)
+			default:
+				app.patchFailedResponse(w, r, err)
+			}
+			return
+		}
+
+		// The patched document holds the complete new state of the movie, so any
+		// field that the patch removed is cleared.
+		err = app.decodeJSON(bytes.NewReader(patched), &input)
+		if err != nil {
+			app.patchFailedResponse(w, r, err)
+			return
+		}
+		movie.Title, movie.Titles, movie.Year, movie.Runtime, movie.Genres = "", []data.MovieTitle{}, 0, 0, nil
+		movie.ExternalIDs = data.ExternalIDs{}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
 		return
 	}
 
@@ -128,6 +267,13 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Title != nil {
 		movie.Title = *input.Title
 	}
+	if input.Titles != nil {
+		movie.Titles = input.Titles
+		data.NormalizeTitles(movie.Titles)
+	}
+	if input.ExternalIDs != nil {
+		movie.ExternalIDs = data.NormalizeExternalIDs(input.ExternalIDs)
+	}
 	if input.Year != nil {
 		movie.Year = *input.Year
 	}
@@ -138,28 +284,57 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		movie.Genres = input.Genres
 	}
 
+	// Normalize the genres to their canonical slugs.
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	movie.Genres = vocabulary.Normalize(movie.Genres)
+
 	// Validate the updated movie record, sending the client a 422 Unprocessable Entity
 	// response if any checks fail.
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, vocabulary); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Update movie db record.
-	err = app.models.Movies.Update(movie)
+	err = app.checkExternalIDs(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Update movie db record. A conditional request that loses a race against
+	// another update fails its precondition.
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "must not contain ids that belong to another movie")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	app.wakeWebhooks()
+
+	headers := make(http.Header)
+	headers.Set("ETag", app.movieETag(movie, ""))
+
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.formatMovie(r, movie)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -173,8 +348,8 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Delete the movie from the database.
-	err = app.models.Movies.Delete(id)
+	// Fetch the existing movie record from db.
+	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -185,17 +360,209 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// If the client sent an If-Match header, only delete the movie if it's still
+	// at the version the client has.
+	if !app.movieIfMatch(r, movie) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// Move the movie to the trash, from where it can be restored until it's purged.
+	err = app.models.Movies.Trash(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeWebhooks()
+
+	// The entity tag of the trashed movie is the one to restore it with.
+	headers := make(http.Header)
+	headers.Set("ETag", app.movieETag(movie, ""))
+
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully moved to trash"}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeMovieHandler handles the "POST /v1/movies/:id/merge" endpoint. The movie
+// with the given source ID is a duplicate, whose genres, localized titles and
+// external identifiers are added to the movie, and whose ratings, reviews,
+// credits and list entries are moved to it, before it's removed. The genres to
+// keep can be chosen from those of both movies, which is required if there are
+// more of them than a movie can have.
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		SourceID int64    `json:"source_id"`
+		Genres   []string `json:"genres"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.SourceID > 0, "source_id", "must be a positive integer")
+	v.Check(input.SourceID != id, "source_id", "must not be the movie itself")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the client sent an If-Match header, only merge into the movie if it's
+	// still at the version the client has.
+	if !app.movieIfMatch(r, movie) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	source, err := app.models.Movies.Get(input.SourceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("source_id", "must refer to an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Combine the genres of both movies, keeping those of the movie first. A movie
+	// can't have more than 5 genres, so if they have more between them, the client
+	// has to choose which of them to keep.
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	genres := append([]string{}, movie.Genres...)
+	combined := vocabulary.Normalize(append(genres, source.Genres...))
+
+	if input.Genres != nil {
+		movie.Genres = vocabulary.Normalize(input.Genres)
+		for _, genre := range movie.Genres {
+			v.Check(validator.In(genre, combined...), "genres", "must only contain genres of the two movies")
+		}
+	} else {
+		movie.Genres = combined
+		v.Check(len(combined) <= 5, "genres", fmt.Sprintf("must be provided, as the movies have %d genres between them (%s), more than the 5 a movie can have", len(combined), strings.Join(combined, ", ")))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Add the localized titles of the source movie in the languages that the movie
+	// has no title in. Its original title is only kept if the movie has none.
+	hasOriginal := false
+	for _, title := range movie.Titles {
+		hasOriginal = hasOriginal || title.Original
+	}
+	for _, title := range source.Titles {
+		if movie.HasTitle(title.Language) {
+			continue
+		}
+		title.Original = title.Original && !hasOriginal
+		movie.Titles = append(movie.Titles, title)
+	}
+
+	// Add the external identifiers of the source movie in the namespaces that the
+	// movie has no identifier in.
+	for namespace, value := range source.ExternalIDs {
+		if _, ok := movie.ExternalIDs[namespace]; !ok {
+			movie.ExternalIDs[namespace] = value
+		}
+	}
+
+	if data.ValidateMovie(v, movie, vocabulary); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Merge(movie, source, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The image metadata of the source movie was removed with it, but its files
+	// have to be removed from storage separately.
+	err = app.storage.DeleteAll(data.MovieImagesPrefix(source.ID))
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	app.wakeWebhooks()
+
+	headers := make(http.Header)
+	headers.Set("ETag", app.movieETag(movie, ""))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.formatMovie(r, movie)}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// readMovieQuery reads the criteria for selecting movies in a listing from the
+// query string.
+func (app *application) readMovieQuery(qs url.Values, v *validator.Validator) data.MovieQuery {
+	return data.MovieQuery{
+		Title:        app.readString(qs, "title", ""),
+		Genres:       app.readCSV(qs, "genres", []string{}),
+		GenresAny:    app.readCSV(qs, "genres_any", []string{}),
+		YearMin:      app.readInt(qs, "year_min", 0, v),
+		YearMax:      app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:   app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:   app.readInt(qs, "runtime_max", 0, v),
+		Person:       int64(app.readInt(qs, "person", 0, v)),
+		SearchMode:   app.readString(qs, "search_mode", data.SearchPlain),
+		SearchConfig: app.readString(qs, "search_config", "simple"),
+		Fuzzy:        app.readBool(qs, "fuzzy", false, v),
+	}
+}
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieQuery
+		Facets []string
 		data.Filters
 	}
 
@@ -204,27 +571,196 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Get the url.Values map containing the query string data.
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieQuery = app.readMovieQuery(qs, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "rating", "relevance", "-id", "-title", "-year", "-runtime", "-rating"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.Total = app.readString(qs, "total", data.TotalExact)
+	input.Filters.Fields = app.readCSV(qs, "fields", []string{})
+	input.Filters.FieldSafelist = []string{"id", "title", "titles", "external_ids", "year", "runtime", "genres", "version", "average_rating", "ratings_count", "highlight"}
 
+	data.ValidateMovieQuery(v, input.MovieQuery)
+	data.ValidateFacets(v, input.Facets)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Genres are matched by their canonical slugs.
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.Genres = vocabulary.Normalize(input.Genres)
+	input.GenresAny = vocabulary.Normalize(input.GenresAny)
+
 	// Retrieve movie db records.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Show the titles in the language preferred by the client.
+	languages := app.readLanguages(r)
+	for _, movie := range movies {
+		movie.Localize(languages)
+	}
+	w.Header().Add("Vary", "Accept-Language")
+
+	env := envelope{"movies": app.formatMovies(r, movies), "metadata": metadata}
+
+	// Only include the requested fields of each movie in a sparse fieldset.
+	if len(input.Filters.Fields) > 0 {
+		format := app.contextGetRuntimeFormat(r)
+		selected := make([]map[string]interface{}, len(movies))
+		for i, movie := range movies {
+			selected[i] = movie.SelectFields(input.Filters.Fields, format)
+		}
+		env["movies"] = selected
+	}
+
+	// Count the movies per facet value over all pages of the listing.
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.GetFacets(input.MovieQuery, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTrashedMoviesHandler handles the "GET /v1/movies/trash" endpoint.
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+	input.Filters.Total = data.TotalExact
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the trashed movie db records.
+	movies, metadata, err := app.models.Movies.GetAllTrashed(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": app.formatMovies(r, movies), "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieHandler handles the "POST /v1/movies/:id/restore" endpoint.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Fetch the movie from the trash, with its localized titles and external
+	// identifiers.
+	movie, err := app.models.Movies.GetTrashed(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the client sent an If-Match header, only restore the movie if it's still
+	// at the version the client has.
+	if !app.movieIfMatch(r, movie) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// Move the movie out of the trash.
+	err = app.models.Movies.Restore(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeWebhooks()
+
+	headers := make(http.Header)
+	headers.Set("ETag", app.movieETag(movie, ""))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": app.formatMovie(r, movie)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// autocompleteMoviesHandler handles the "GET /v1/movies/autocomplete" endpoint.
+func (app *application) autocompleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.Check(input.Query != "", "q", "must be provided")
+	v.Check(len(input.Query) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Autocomplete(input.Query, input.Limit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+)
+
+func TestShowMovieETagChangesWithRating(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+	movie := newTestMovie(t, app, user, "Moana")
+	id := strconv.FormatInt(movie.ID, 10)
+
+	rr := serveTest(app, app.showMovieHandler, newTestRequest(http.MethodGet, "/v1/movies/"+id, ""), user, "id", id)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+	etag := rr.Header().Get("ETag")
+
+	// Ratings change the aggregates in the body, but not the version.
+	rr = serveTest(app, app.createMovieRatingHandler, newTestRequest(http.MethodPost, "/v1/movies/"+id+"/rating", `{"rating": 4}`), user, "id", id)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d rating the movie; want %d", rr.Code, http.StatusCreated)
+	}
+
+	r := newTestRequest(http.MethodGet, "/v1/movies/"+id, "")
+	r.Header.Set("If-None-Match", etag)
+
+	rr = serveTest(app, app.showMovieHandler, r, user, "id", id)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d with the entity tag from before the rating; want %d", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("ETag"); got == etag {
+		t.Errorf("got the same entity tag %s after the rating", got)
+	}
+
+	r = newTestRequest(http.MethodGet, "/v1/movies/"+id, "")
+	r.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+
+	rr = serveTest(app, app.showMovieHandler, r, user, "id", id)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("got status %d with the current entity tag; want %d", rr.Code, http.StatusNotModified)
+	}
+}
+
+func TestTrashAndRestoreMovieIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+	movie := newTestMovie(t, app, user, "Moana")
+	id := strconv.FormatInt(movie.ID, 10)
+
+	movie.Titles = []data.MovieTitle{{Language: "sv", Title: "Vaiana"}}
+	movie.ExternalIDs = data.ExternalIDs{"imdb": "tt3521164"}
+	err := app.models.Movies.Update(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRequest(http.MethodDelete, "/v1/movies/"+id, "")
+	r.Header.Set("If-Match", app.movieETag(movie, ""))
+
+	rr := serveTest(app, app.deleteMovieHandler, r, user, "id", id)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d moving the movie to the trash; want %d", rr.Code, http.StatusOK)
+	}
+	trashed := rr.Header().Get("ETag")
+	if trashed == "" || trashed == app.movieETag(movie, "") {
+		t.Fatalf("got entity tag %q for the trashed movie", trashed)
+	}
+
+	// The movie can't be restored with the entity tag from before it was trashed.
+	r = newTestRequest(http.MethodPost, "/v1/movies/"+id+"/restore", "")
+	r.Header.Set("If-Match", app.movieETag(movie, ""))
+
+	rr = serveTest(app, app.restoreMovieHandler, r, user, "id", id)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("got status %d with a stale entity tag; want %d", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	r = newTestRequest(http.MethodPost, "/v1/movies/"+id+"/restore", "")
+	r.Header.Set("If-Match", trashed)
+
+	rr = serveTest(app, app.restoreMovieHandler, r, user, "id", id)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d with the entity tag of the trashed movie; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var response struct {
+		Movie data.Movie `json:"movie"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Movie.Titles) != 1 || response.Movie.ExternalIDs["imdb"] != "tt3521164" {
+		t.Errorf("got titles %v and external ids %v; want those of the movie", response.Movie.Titles, response.Movie.ExternalIDs)
+	}
+
+	restored, err := app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rr.Header().Get("ETag"), app.movieETag(restored, ""); got != want {
+		t.Errorf("got entity tag %s; want %s", got, want)
+	}
+}
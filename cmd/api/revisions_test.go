@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+)
+
+func TestRestoreMovieRevisionIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+	movie := newTestMovie(t, app, user, "Moana")
+	id := strconv.FormatInt(movie.ID, 10)
+	stale := app.movieETag(movie, "")
+
+	movie.Title = "Vaiana"
+	err := app.models.Movies.Update(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRequest(http.MethodPost, "/v1/movies/"+id+"/revisions/1/restore", "")
+	r.Header.Set("If-Match", stale)
+
+	rr := serveTest(app, app.restoreMovieRevisionHandler, r, user, "id", id, "version", "1")
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("got status %d with a stale entity tag; want %d", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	r = newTestRequest(http.MethodPost, "/v1/movies/"+id+"/revisions/1/restore", "")
+	r.Header.Set("If-Match", app.movieETag(movie, ""))
+
+	rr = serveTest(app, app.restoreMovieRevisionHandler, r, user, "id", id, "version", "1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d with the current entity tag; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	restored, err := app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Title != "Moana" {
+		t.Errorf("got title %q; want %q", restored.Title, "Moana")
+	}
+	if got, want := rr.Header().Get("ETag"), app.movieETag(restored, ""); got != want {
+		t.Errorf("got entity tag %s; want %s", got, want)
+	}
+}
+
+func TestTrashAndRestoreRecordRevisions(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+	movie := newTestMovie(t, app, user, "Moana")
+
+	err := app.models.Movies.Trash(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Movies.Restore(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "version", SortSafelist: []string{"version"}, Total: data.TotalExact}
+	revisions, _, err := app.models.Revisions.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every version of the movie has a revision.
+	if len(revisions) != int(movie.Version) {
+		t.Fatalf("got %d revisions of a movie at version %d", len(revisions), movie.Version)
+	}
+	for i, revision := range revisions {
+		if revision.Version != int32(i+1) {
+			t.Errorf("got version %d at index %d", revision.Version, i)
+		}
+		if changes := data.DiffRevisions(revisions[0], revision, data.RuntimeFormatDefault); len(changes) != 0 {
+			t.Errorf("got changes %v in version %d", changes, revision.Version)
+		}
+	}
+}
+
+func TestRestoreMovieRevisionTitlesAndExternalIDs(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+	movie := newTestMovie(t, app, user, "Moana")
+	id := strconv.FormatInt(movie.ID, 10)
+
+	movie.Titles = []data.MovieTitle{{Language: "sv", Title: "Vaiana"}}
+	movie.ExternalIDs = data.ExternalIDs{"imdb": "tt3521164"}
+	err := app.models.Movies.Update(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := strconv.Itoa(int(movie.Version))
+
+	movie.Titles = []data.MovieTitle{}
+	movie.ExternalIDs = data.ExternalIDs{}
+	err = app.models.Movies.Update(movie, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := serveTest(app, app.restoreMovieRevisionHandler, newTestRequest(http.MethodPost, "/v1/movies/"+id+"/revisions/"+version+"/restore", ""), user, "id", id, "version", version)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var response map[string]json.RawMessage
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unrestored, ok := response["unrestored"]; ok {
+		t.Errorf("got unrestored fields %s", unrestored)
+	}
+
+	restored, err := app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Titles) != 1 || restored.Titles[0].Title != "Vaiana" {
+		t.Errorf("got titles %v; want the Swedish title", restored.Titles)
+	}
+	if restored.ExternalIDs["imdb"] != "tt3521164" {
+		t.Errorf("got external ids %v; want the IMDb id", restored.ExternalIDs)
+	}
+
+	// A revision from before the titles and external identifiers were recorded
+	// leaves them as they are, and flags them.
+	_, err = app.models.Movies.DB.Exec(`UPDATE movie_revisions SET titles = NULL, external_ids = NULL WHERE movie_id = $1 AND version = 1`, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = serveTest(app, app.restoreMovieRevisionHandler, newTestRequest(http.MethodPost, "/v1/movies/"+id+"/revisions/1/restore", ""), user, "id", id, "version", "1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var flagged struct {
+		Unrestored []string `json:"unrestored"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&flagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flagged.Unrestored) != 2 || flagged.Unrestored[0] != "titles" || flagged.Unrestored[1] != "external_ids" {
+		t.Errorf("got unrestored fields %v; want titles and external_ids", flagged.Unrestored)
+	}
+
+	restored, err = app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Titles) != 1 || restored.ExternalIDs["imdb"] != "tt3521164" {
+		t.Errorf("got titles %v and external ids %v; want them left as they were", restored.Titles, restored.ExternalIDs)
+	}
+}
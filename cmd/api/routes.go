@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lsjoeberg/greenlight/internal/data"
 )
 
 func (app *application) routes() http.Handler {
@@ -14,14 +15,110 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// httprouter doesn't allow a static path segment in the same position as a
+	// wildcard segment, e.g. /v1/movies/trash and /v1/movies/:id. Such routes are
+	// registered on a separate router, which takes precedence over the main one.
+	static := httprouter.New()
+
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
 	// Movies routes; only activated users allowed.
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.negotiateRuntimeFormat(app.listMoviesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.createMovieHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.negotiateRuntimeFormat(app.showMovieHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.updateMovieHandler)))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.mergeMovieHandler)))
+
+	// Movies search routes.
+	static.HandlerFunc(http.MethodGet, "/v1/movies/autocomplete", app.requirePermission("movies:read", app.autocompleteMoviesHandler))
+
+	// Movies external ID routes; movies are looked up by their IDs in other databases.
+	static.HandlerFunc(http.MethodGet, "/v1/movies/by-external/:namespace/:value", app.requirePermission("movies:read", app.negotiateRuntimeFormat(app.movieByExternalID(app.showMovieHandler))))
+	static.HandlerFunc(http.MethodPatch, "/v1/movies/by-external/:namespace/:value", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.movieByExternalID(app.updateMovieHandler))))
+
+	// Movies bulk routes.
+	static.HandlerFunc(http.MethodPost, "/v1/movies/import", app.requirePermission("movies:write", app.importMoviesHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/movies/export", app.requirePermission("movies:read", app.negotiateRuntimeFormat(app.exportMoviesHandler)))
+
+	// Movies change feed routes.
+	static.HandlerFunc(http.MethodGet, "/v1/movies/changes", app.requirePermission("movies:read", app.listMovieChangesHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/movies/stream", app.requirePermission("movies:read", app.streamMoviesHandler))
+
+	// Movies trash routes.
+	static.HandlerFunc(http.MethodGet, "/v1/movies/trash", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.listTrashedMoviesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.restoreMovieHandler)))
+
+	// Movie revisions routes.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.negotiateRuntimeFormat(app.listMovieRevisionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.negotiateRuntimeFormat(app.showMovieRevisionHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.requirePermission("movies:write", app.negotiateRuntimeFormat(app.restoreMovieRevisionHandler)))
+
+	// Movie ratings and reviews routes.
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/rating", app.requirePermission("reviews:write", app.createMovieRatingHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/rating", app.requirePermission("reviews:write", app.updateMovieRatingHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requirePermission("reviews:write", app.deleteMovieRatingHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:user_id", app.requirePermission("reviews:moderate", app.deleteMovieReviewHandler))
+
+	// Movie credits routes.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteMovieCreditHandler))
+
+	// Movie images routes.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/poster", app.requirePermission("movies:read", app.showMovieImageHandler(data.ImagePoster)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.putMovieImageHandler(data.ImagePoster)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.deleteMovieImageHandler(data.ImagePoster)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/backdrop", app.requirePermission("movies:read", app.showMovieImageHandler(data.ImageBackdrop)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/backdrop", app.requirePermission("movies:write", app.putMovieImageHandler(data.ImageBackdrop)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/backdrop", app.requirePermission("movies:write", app.deleteMovieImageHandler(data.ImageBackdrop)))
+
+	// Genres routes; the vocabulary is managed by administrators.
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:slug", app.requirePermission("movies:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:slug", app.requirePermission("genres:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:slug", app.requirePermission("genres:write", app.deleteGenreHandler))
+
+	// People routes.
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id/filmography", app.requirePermission("movies:read", app.showFilmographyHandler))
+
+	// Lists routes; lists are owned by the user who created them.
+	router.HandlerFunc(http.MethodGet, "/v1/lists", app.requireActivatedUser(app.listListsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/lists", app.requireActivatedUser(app.createListHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/lists/watchlist", app.requireActivatedUser(app.showWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id", app.requireActivatedUser(app.showListHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:id", app.requireActivatedUser(app.updateListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id", app.requireActivatedUser(app.deleteListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:id/entries", app.requireActivatedUser(app.listListEntriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/lists/:id/entries", app.requireActivatedUser(app.addListEntryHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/lists/:id/entries/:movie_id", app.requireActivatedUser(app.updateListEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/lists/:id/entries/:movie_id", app.requireActivatedUser(app.removeListEntryHandler))
+
+	// Webhooks routes; webhooks are managed by administrators.
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:admin", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:admin", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:admin", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:admin", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:admin", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:admin", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requirePermission("webhooks:admin", app.redeliverWebhookDeliveryHandler))
+
+	// Saved searches routes; saved searches are private to the user who created
+	// them, but can be unsubscribed from with the token in the notification emails.
+	router.HandlerFunc(http.MethodGet, "/v1/saved-searches", app.requireActivatedUser(app.listSavedSearchesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/saved-searches", app.requireActivatedUser(app.createSavedSearchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/saved-searches/:id", app.requireActivatedUser(app.showSavedSearchHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/saved-searches/:id", app.requireActivatedUser(app.updateSavedSearchHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/saved-searches/:id", app.requireActivatedUser(app.deleteSavedSearchHandler))
+	static.HandlerFunc(http.MethodGet, "/v1/saved-searches/unsubscribe", app.showUnsubscribeHandler)
+	static.HandlerFunc(http.MethodPost, "/v1/saved-searches/unsubscribe", app.unsubscribeSavedSearchHandler)
 
 	// Users routes.
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
@@ -34,5 +131,17 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
 	// Middleware chain.
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(app.dispatch(static, router))))))
+}
+
+// dispatch serves requests with the static router if it has a matching route,
+// and with the main router otherwise.
+func (app *application) dispatch(static, router *httprouter.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, _, _ := static.Lookup(r.Method, r.URL.Path); handle != nil {
+			static.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
 }
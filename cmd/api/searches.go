@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// savedSearchDigestSize is the maximum number of movies listed in a digest.
+const savedSearchDigestSize = 20
+
+// savedSearchTokenTTL is how long the unsubscribe link in a digest stays valid.
+const savedSearchTokenTTL = 30 * 24 * time.Hour
+
+// readSavedSearch fetches the saved search named by the "id" URL parameter.
+// Saved searches of other users are reported as not found.
+func (app *application) readSavedSearch(r *http.Request) (*data.SavedSearch, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	search, err := app.models.Searches.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if search.UserID != app.contextGetUser(r).ID {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return search, nil
+}
+
+// normalizeSavedQuery sets the search mode and configuration of a saved query to
+// the defaults of the query string parameters if they're empty, and normalizes
+// its genres to their canonical slugs.
+func (app *application) normalizeSavedQuery(q *data.MovieQuery) error {
+	if q.SearchMode == "" {
+		q.SearchMode = data.SearchPlain
+	}
+	if q.SearchConfig == "" {
+		q.SearchConfig = "simple"
+	}
+
+	vocabulary, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		return err
+	}
+	q.Genres = vocabulary.Normalize(q.Genres)
+	q.GenresAny = vocabulary.Normalize(q.GenresAny)
+
+	return nil
+}
+
+// createSavedSearchHandler handles the "POST /v1/saved-searches" endpoint. The
+// query holds the parameters accepted by the "GET /v1/movies" endpoint, other
+// than those for pagination, sorting and field selection.
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string          `json:"name"`
+		Query  data.MovieQuery `json:"query"`
+		Notify *bool           `json:"notify"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	search := &data.SavedSearch{
+		UserID: app.contextGetUser(r).ID,
+		Name:   input.Name,
+		Query:  input.Query,
+		Notify: true,
+	}
+	if input.Notify != nil {
+		search.Notify = *input.Notify
+	}
+
+	err = app.normalizeSavedQuery(&search.Query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Searches.Insert(search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/saved-searches/%d", search.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"saved_search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSavedSearchesHandler handles the "GET /v1/saved-searches" endpoint, which
+// lists the saved searches of the current user.
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+	input.Filters.Total = data.TotalExact
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	searches, metadata, err := app.models.Searches.GetAllForUser(app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_searches": searches, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showSavedSearchHandler handles the "GET /v1/saved-searches/:id" endpoint.
+func (app *application) showSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := app.readSavedSearch(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateSavedSearchHandler handles the "PATCH /v1/saved-searches/:id" endpoint.
+// A query replaces the whole query of the saved search.
+func (app *application) updateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := app.readSavedSearch(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name   *string          `json:"name"`
+		Query  *data.MovieQuery `json:"query"`
+		Notify *bool            `json:"notify"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		search.Name = *input.Name
+	}
+	if input.Query != nil {
+		search.Query = *input.Query
+	}
+	if input.Notify != nil {
+		search.Notify = *input.Notify
+	}
+
+	err = app.normalizeSavedQuery(&search.Query)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Searches.Update(search)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSavedSearchHandler handles the "DELETE /v1/saved-searches/:id" endpoint.
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := app.readSavedSearch(r)
+	if err == nil {
+		err = app.models.Searches.Delete(search.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "saved search successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUnsubscribeToken reads and validates the unsubscribe token in the query
+// string of a request. If it's invalid, a response has been sent and false is
+// returned.
+func (app *application) readUnsubscribeToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := app.readString(r.URL.Query(), "token", "")
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return token, true
+}
+
+// invalidUnsubscribeTokenResponse sends the response for an unsubscribe token
+// that doesn't exist or has expired.
+func (app *application) invalidUnsubscribeTokenResponse(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	v.AddError("token", "invalid or expired unsubscribe token")
+	app.failedValidationResponse(w, r, v.Errors)
+}
+
+// showUnsubscribeHandler handles the "GET /v1/saved-searches/unsubscribe"
+// endpoint, which is linked from the notification emails. It only names the
+// saved search that the token in the query string belongs to, as links are
+// often followed by email scanners and prefetchers without the user clicking
+// them, and the user confirms with a POST request to the same URL.
+func (app *application) showUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readUnsubscribeToken(w, r)
+	if !ok {
+		return
+	}
+
+	search, err := app.models.Searches.GetForUnsubscribeToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidUnsubscribeTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"saved_search": envelope{"name": search.Name, "notify": search.Notify},
+		"message":      "send a POST request to this URL to stop being notified of new movies matching this search",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unsubscribeSavedSearchHandler handles the "POST /v1/saved-searches/unsubscribe"
+// endpoint, which turns off the notifications of the saved search that the token
+// in the query string belongs to. It's also used for one-click unsubscribing by
+// email clients. The token identifies the saved search, so no authentication is
+// needed.
+func (app *application) unsubscribeSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readUnsubscribeToken(w, r)
+	if !ok {
+		return
+	}
+
+	search, err := app.models.Searches.Unsubscribe(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidUnsubscribeTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	message := fmt.Sprintf("you will no longer be notified of new movies matching %q", search.Name)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendSavedSearchDigest matches a saved search against the movies created after
+// its last run, up to the change with the sequence number, and emails any new
+// matches to its owner in the background. It reports whether an email was
+// queued. The search is advanced first, so that each match is only sent once,
+// even if the email can't be sent.
+func (app *application) sendSavedSearchDigest(search *data.DueSavedSearch, upTo int64) (bool, error) {
+	advanced, err := app.models.Searches.Advance(search.SavedSearch, upTo)
+	if err != nil || !advanced {
+		return false, err
+	}
+
+	movies, total, err := app.models.Movies.GetCreatedMatches(search.Query, search.LastSeq, upTo, savedSearchDigestSize)
+	if err != nil || total == 0 {
+		return false, err
+	}
+
+	token, err := app.models.Searches.NewUnsubscribeToken(search.ID, savedSearchTokenTTL)
+	if err != nil {
+		return false, err
+	}
+	unsubscribeURL := app.config.baseURL + "/v1/saved-searches/unsubscribe?token=" + url.QueryEscape(token)
+
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"userName":       search.UserName,
+			"name":           search.Name,
+			"movies":         movies,
+			"total":          total,
+			"more":           total - len(movies),
+			"unsubscribeURL": unsubscribeURL,
+		}
+		err := app.mailer.Send(search.UserEmail, "saved_search_digest.tmpl", emailData)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"saved_search_id": strconv.FormatInt(search.ID, 10),
+			})
+		}
+	})
+
+	return true, nil
+}
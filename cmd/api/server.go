@@ -21,6 +21,9 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// End the movie streams on shutdown, as their connections never become idle.
+	srv.RegisterOnShutdown(app.movieStream.shutdown)
+
 	// Create a shutdownError channel to receive any errors returned by the graceful Shutdown() function.
 	shutdownError := make(chan error)
 
@@ -44,7 +47,9 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
-		// Wait for any background goroutines to complete their tasks.
+		// Stop the background workers, and wait for any background goroutines to
+		// complete their tasks.
+		close(app.shutdown)
 		app.logger.PrintInfo("completing background tasks", map[string]string{"addr": srv.Addr})
 		app.wg.Wait()
 		shutdownError <- nil
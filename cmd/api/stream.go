@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/data"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// streamHeartbeatInterval is the time between the heartbeats sent on an idle
+// movie stream, which keep proxies from closing the connection.
+const streamHeartbeatInterval = 20 * time.Second
+
+// movieStream fans out the movie changes notified by the database to the clients
+// of the movie stream. Each client subscribes with a channel, which is closed
+// when the client falls behind or notifications may have been missed, after
+// which the client has to catch up from the change feed.
+type movieStream struct {
+	mu          sync.Mutex
+	subscribers map[chan *data.MovieChange]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newMovieStream() *movieStream {
+	return &movieStream{
+		subscribers: make(map[chan *data.MovieChange]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// subscribe returns a new channel that receives the changes notified from now on.
+func (s *movieStream) subscribe() chan *data.MovieChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := make(chan *data.MovieChange, 64)
+	s.subscribers[changes] = struct{}{}
+	return changes
+}
+
+// unsubscribe removes the channel from the subscribers, if it's still there.
+func (s *movieStream) unsubscribe(changes chan *data.MovieChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[changes]; ok {
+		delete(s.subscribers, changes)
+		close(changes)
+	}
+}
+
+// publish sends a change to every subscriber. Subscribers that have fallen
+// behind are dropped rather than waited for.
+func (s *movieStream) publish(change *data.MovieChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for changes := range s.subscribers {
+		select {
+		case changes <- change:
+		default:
+			delete(s.subscribers, changes)
+			close(changes)
+		}
+	}
+}
+
+// resync drops every subscriber, so that they all catch up from the change feed.
+func (s *movieStream) resync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for changes := range s.subscribers {
+		delete(s.subscribers, changes)
+		close(changes)
+	}
+}
+
+// shutdown ends all streams, which would otherwise keep the server from shutting
+// down gracefully.
+func (s *movieStream) shutdown() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// listenForMovieChanges publishes the movie changes notified to the listener on
+// the movie stream, until the stream is shut down. Every API instance listens
+// for the changes, whichever instance made them.
+func (app *application) listenForMovieChanges(listener *pq.Listener) {
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			// A nil notification is sent after the connection to the database
+			// has been re-established, and notifications may have been missed.
+			if n == nil {
+				app.movieStream.resync()
+				continue
+			}
+
+			change, err := data.ParseMovieChangeNotification(n.Extra)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				app.movieStream.resync()
+				continue
+			}
+			app.movieStream.publish(change)
+
+		case <-ping.C:
+			// Check the connection, which is re-established if it's been lost.
+			go listener.Ping()
+
+		case <-app.movieStream.done:
+			return
+		}
+	}
+}
+
+// streamMoviesHandler handles the "GET /v1/movies/stream" endpoint, which sends
+// the changes to movies as server-sent events as they're committed. The ID of
+// each event is the change feed token following the change, so a client that
+// reconnects with the Last-Event-ID header resumes where it left off. The since
+// parameter sets the position to start from on the first connection, which is
+// otherwise the latest change. The authentication token and permission of the
+// user are checked again with each heartbeat, and the stream is closed when
+// they're no longer valid.
+func (app *application) streamMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Last-Event-ID")
+	key := "last_event_id"
+	if token == "" {
+		token = app.readString(r.URL.Query(), "since", "")
+		key = "since"
+	}
+
+	// Subscribe before reading the position to start from, so that no changes
+	// are missed in between.
+	changes := app.movieStream.subscribe()
+	defer func() {
+		app.movieStream.unsubscribe(changes)
+	}()
+
+	var last int64
+	var err error
+	if token != "" {
+		last, err = data.DecodeChangeToken(token)
+	} else {
+		last, err = app.models.Changes.Latest()
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidChangeToken):
+			v := validator.New()
+			v.AddError(key, "invalid change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// send writes to the stream and flushes it. The deadlines are extended with
+	// each write, so that the stream outlives the server timeouts while the
+	// client keeps reading.
+	send := func(format string, args ...interface{}) error {
+		err := app.extendDeadlines(w, 2*streamHeartbeatInterval)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, format, args...)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = send("retry: %d\n\n", 5000)
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		// Catch up from the change feed with the changes that were made while
+		// the client wasn't subscribed.
+		for more := true; more; {
+			feed, metadata, err := app.models.Changes.GetSince(data.EncodeChangeToken(last), 1000)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			for _, change := range feed {
+				err = app.sendMovieChange(send, change)
+				if err != nil {
+					return
+				}
+				last = change.Seq
+			}
+			more = metadata.HasMore
+		}
+
+	live:
+		for {
+			select {
+			case change, ok := <-changes:
+				if !ok {
+					changes = app.movieStream.subscribe()
+					break live
+				}
+				// The change may already have been sent while catching up.
+				if change.Seq <= last {
+					continue
+				}
+				err = app.sendMovieChange(send, change)
+				if err != nil {
+					return
+				}
+				last = change.Seq
+
+			case <-heartbeat.C:
+				if !app.streamAuthorized(r) {
+					return
+				}
+				err = send(": heartbeat\n\n")
+				if err != nil {
+					return
+				}
+
+			case <-r.Context().Done():
+				return
+
+			case <-app.movieStream.done:
+				return
+			}
+		}
+	}
+}
+
+// sendMovieChange writes a change as an event named after the kind of change,
+// such as "movie.created", which is the same as the webhook event.
+func (app *application) sendMovieChange(send func(string, ...interface{}) error, change *data.MovieChange) error {
+	js, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return send("id: %s\nevent: movie.%s\ndata: %s\n\n", data.EncodeChangeToken(change.Seq), change.Kind, js)
+}
+
+// streamAuthorized reports whether the authentication token of a stream request
+// is still valid, and whether its user still has the permission to read movies.
+func (app *application) streamAuthorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logError(r, err)
+		}
+		return false
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.logError(r, err)
+		return false
+	}
+
+	return user.Activated && permissions.Include("movies:read")
+}
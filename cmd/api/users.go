@@ -56,8 +56,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	// Add the "movies:read" and "reviews:write" permissions for the new user.
+	err = app.models.Permissions.AddForUser(user.ID, "movies:read", "reviews:write")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -123,11 +123,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Update the user's activation status.
-	user.Activated = true
-
-	// Save the updated user record in our database, checking for any edit conflicts.
-	err = app.models.Users.Update(user)
+	// Activate the user in our database, checking for any edit conflicts.
+	err = app.models.Users.Activate(user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -145,6 +142,8 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.wakeWebhooks()
+
 	// Send the updated user details to the client in a JSON response.
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
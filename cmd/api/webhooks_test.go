@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/data"
+)
+
+func TestDeliverWebhookRetriesUntilSuccess(t *testing.T) {
+	app := newTestApplication(t)
+	user := newTestUser(t, app)
+
+	// The endpoint fails the first two attempts.
+	const failures = 2
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts
+	}
+
+	hook := &data.Webhook{URL: ts.URL, Events: []string{data.EventMovieCreated}, Secret: "0123456789abcdef", Active: true}
+	err := app.models.Webhooks.Insert(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Creating a movie queues a delivery of the event.
+	newTestMovie(t, app, user, "Moana")
+
+	delivery := func() *data.WebhookDelivery {
+		t.Helper()
+		filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}, Total: data.TotalExact}
+		deliveries, _, err := app.models.Deliveries.GetAllForWebhook(hook.ID, "", filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 {
+			t.Fatalf("got %d deliveries; want 1", len(deliveries))
+		}
+		return deliveries[0]
+	}
+
+	// makeDue moves the next attempt of the delivery to now, instead of waiting
+	// for the backoff to pass.
+	makeDue := func() {
+		t.Helper()
+		_, err := app.models.Deliveries.DB.Exec(`UPDATE webhook_deliveries SET next_attempt_at = NOW() WHERE webhook_id = $1`, hook.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// checkRetry checks that a failed attempt is retried after the delay.
+	checkRetry := func(n int, delay time.Duration) {
+		t.Helper()
+		d := delivery()
+		if d.Status != data.DeliveryPending || int(d.Attempts) != n {
+			t.Fatalf("got status %s after %d attempts; want %s after %d", d.Status, d.Attempts, data.DeliveryPending, n)
+		}
+		if d.ResponseStatus == nil || *d.ResponseStatus != http.StatusServiceUnavailable || d.LastError == nil {
+			t.Errorf("got response status %v and error %v; want the failure recorded", d.ResponseStatus, d.LastError)
+		}
+		if d.NextAttemptAt == nil || d.LastAttemptAt == nil {
+			t.Fatalf("got next attempt %v after last attempt %v; want both", d.NextAttemptAt, d.LastAttemptAt)
+		}
+		if got := d.NextAttemptAt.Sub(*d.LastAttemptAt); got < delay-time.Millisecond || got > delay+time.Millisecond {
+			t.Errorf("got next attempt %s after attempt %d; want %s", got, n, delay)
+		}
+	}
+
+	// A claimed delivery is leased, so it isn't claimed again while it's sent.
+	lease := app.config.webhooks.timeout + time.Minute
+	claimed, err := app.models.Deliveries.Claim(20, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatalf("got %d claimed deliveries; want 1", len(claimed))
+	}
+	if again, err := app.models.Deliveries.Claim(20, lease); err != nil || len(again) != 0 {
+		t.Fatalf("got %d deliveries claiming again (%v); want none while leased", len(again), err)
+	}
+	if d := delivery(); d.NextAttemptAt == nil || time.Until(*d.NextAttemptAt) < lease-5*time.Second {
+		t.Errorf("got next attempt at %v for a claimed delivery; want it leased for %s", d.NextAttemptAt, lease)
+	}
+
+	app.deliverWebhook(claimed[0])
+	checkRetry(1, 30*time.Second)
+
+	// The retry isn't sent before it's due.
+	app.deliverWebhooks()
+	if got := received(); got != 1 {
+		t.Fatalf("got %d attempts before the retry was due; want 1", got)
+	}
+
+	makeDue()
+	app.deliverWebhooks()
+	checkRetry(2, time.Minute)
+
+	makeDue()
+	app.deliverWebhooks()
+
+	d := delivery()
+	if d.Status != data.DeliverySucceeded || d.Attempts != failures+1 {
+		t.Errorf("got status %s after %d attempts; want %s after %d", d.Status, d.Attempts, data.DeliverySucceeded, failures+1)
+	}
+	if d.NextAttemptAt != nil || d.LastError != nil {
+		t.Errorf("got next attempt %v and error %v for a delivered delivery; want neither", d.NextAttemptAt, d.LastError)
+	}
+	if d.ResponseStatus == nil || *d.ResponseStatus != http.StatusOK {
+		t.Errorf("got response status %v; want %d", d.ResponseStatus, http.StatusOK)
+	}
+	if got := received(); got != failures+1 {
+		t.Errorf("got %d requests; want %d", got, failures+1)
+	}
+
+	// A delivered delivery isn't claimed again.
+	makeDue()
+	app.deliverWebhooks()
+	if got := received(); got != failures+1 {
+		t.Errorf("got %d requests after the delivery succeeded; want %d", got, failures+1)
+	}
+}
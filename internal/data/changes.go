@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidChangeToken = errors.New("invalid change token")
+
+// MovieChangesChannel is the database notification channel that each change is
+// notified on as it's committed.
+const MovieChangesChannel = "movie_changes"
+
+// Movie change kinds. A movie moved to the trash is deleted as far as clients
+// are concerned, and a movie restored from the trash is created again.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// MovieChange records a change to a movie in the change feed. The changes are
+// recorded by the database as movies are modified, whichever way they are
+// modified, and deleted movies are kept in the feed as tombstones.
+type MovieChange struct {
+	Seq       int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Kind      string    `json:"kind"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// ChangesMetadata holds the position in the change feed to continue from.
+type ChangesMetadata struct {
+	NextToken string `json:"next_token"`
+	HasMore   bool   `json:"has_more"`
+}
+
+// EncodeChangeToken returns the opaque token for the position in the feed after
+// the change with the given sequence number.
+func EncodeChangeToken(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(seq, 10)))
+}
+
+// DecodeChangeToken parses a token created by EncodeChangeToken, and returns the
+// sequence number of the change before the position. The empty token is the
+// start of the feed.
+func DecodeChangeToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidChangeToken
+	}
+
+	seq, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, ErrInvalidChangeToken
+	}
+	return seq, nil
+}
+
+// MovieChangeModel represents a model of the movie change feed.
+type MovieChangeModel struct {
+	DB *sql.DB
+}
+
+// GetSince returns up to limit changes following the position of the token, in
+// the order the changes were committed. The returned metadata holds the token to
+// continue from, and reports whether there are more changes after it.
+func (m MovieChangeModel) GetSince(token string, limit int) ([]*MovieChange, ChangesMetadata, error) {
+	seq, err := DecodeChangeToken(token)
+	if err != nil {
+		return nil, ChangesMetadata{}, err
+	}
+
+	// One row more than the limit is fetched to find out if there are more.
+	query := `
+		SELECT seq, movie_id, kind, changed_at
+		FROM movie_changes
+		WHERE seq > $1
+		ORDER BY seq ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, seq, limit+1)
+	if err != nil {
+		return nil, ChangesMetadata{}, err
+	}
+	defer rows.Close()
+
+	changes := []*MovieChange{}
+	metadata := ChangesMetadata{}
+
+	for rows.Next() {
+		if len(changes) == limit {
+			metadata.HasMore = true
+			break
+		}
+
+		var change MovieChange
+		err := rows.Scan(&change.Seq, &change.MovieID, &change.Kind, &change.ChangedAt)
+		if err != nil {
+			return nil, ChangesMetadata{}, err
+		}
+		seq = change.Seq
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ChangesMetadata{}, err
+	}
+
+	// The token moves past the last returned change, or stays in place if there
+	// were none.
+	metadata.NextToken = EncodeChangeToken(seq)
+
+	return changes, metadata, nil
+}
+
+// Latest returns the sequence number of the latest change in the feed, or zero
+// if the feed is empty.
+func (m MovieChangeModel) Latest() (int64, error) {
+	query := `SELECT COALESCE(max(seq), 0) FROM movie_changes`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var seq int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&seq)
+	return seq, err
+}
+
+// ParseMovieChangeNotification decodes the payload of a notification on the
+// MovieChangesChannel.
+func ParseMovieChangeNotification(payload string) (*MovieChange, error) {
+	var notification struct {
+		Seq       int64     `json:"seq"`
+		MovieID   int64     `json:"movie_id"`
+		Kind      string    `json:"kind"`
+		ChangedAt time.Time `json:"changed_at"`
+	}
+
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MovieChange{
+		Seq:       notification.Seq,
+		MovieID:   notification.MovieID,
+		Kind:      notification.Kind,
+		ChangedAt: notification.ChangedAt,
+	}, nil
+}
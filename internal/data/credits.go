@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// Credit roles.
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+// CreditRoleSafelist holds the permitted credit roles, in the order in which
+// they are listed.
+var CreditRoleSafelist = []string{RoleDirector, RoleWriter, RoleActor}
+
+// Credit represents a person's role in a movie. Credits of the same role are
+// ordered by their billing order. The person is included when listing the credits
+// of a movie, and the movie when listing the filmography of a person.
+type Credit struct {
+	ID           int64   `json:"id"`
+	MovieID      int64   `json:"movie_id"`
+	PersonID     int64   `json:"person_id"`
+	Role         string  `json:"role"`
+	Character    string  `json:"character,omitempty"`
+	BillingOrder int32   `json:"billing_order"`
+	Person       *Person `json:"person,omitempty"`
+	Movie        *Movie  `json:"movie,omitempty"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID != 0, "person_id", "must be provided")
+	v.Check(credit.PersonID >= 0, "person_id", "must be a positive integer")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoleSafelist...), "role", "invalid role value")
+
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.Character == "" || credit.Role == RoleActor, "character", "must only be provided for actors")
+
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+// CreditModel represents a model of the credits store.
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new record in the credits table.
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+		INSERT INTO credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		case err.Error() == `pq: insert or update on table "credits" violates foreign key constraint "credits_person_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForMovie returns the credits of a specific movie, ordered by role and
+// billing order, optionally only those of a specific role.
+func (m CreditModel) GetAllForMovie(movieID int64, role string) ([]*Credit, error) {
+	query := `
+		SELECT credits.id, credits.person_id, credits.role, credits.character, credits.billing_order,
+			people.name, coalesce(people.birth_year, 0), people.version
+		FROM credits
+			INNER JOIN people ON people.id = credits.person_id
+		WHERE credits.movie_id = $1 AND (credits.role = $2 OR $2 = '')
+		ORDER BY array_position($3::text[], credits.role), credits.billing_order, credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, role, pq.Array(CreditRoleSafelist))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		credit := Credit{MovieID: movieID, Person: &Person{}}
+		err := rows.Scan(
+			&credit.ID,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+			&credit.Person.Name,
+			&credit.Person.BirthYear,
+			&credit.Person.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credit.Person.ID = credit.PersonID
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetAllForPerson returns a slice of the credits of a specific person, along with
+// their movies. Movies in the trash are left out.
+func (m CreditModel) GetAllForPerson(personID int64, filters Filters) ([]*Credit, Metadata, error) {
+	sortExpressions := map[string]string{
+		"title": "movies.title",
+		"year":  "movies.year",
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), credits.id, credits.movie_id, credits.role, credits.character,
+			credits.billing_order, movies.title, movies.year, movies.runtime, movies.genres, movies.version
+		FROM credits
+			INNER JOIN movies ON movies.id = credits.movie_id
+		WHERE credits.person_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s, credits.id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy(sortExpressions))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	credits := []*Credit{}
+
+	for rows.Next() {
+		credit := Credit{PersonID: personID, Movie: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&credit.ID,
+			&credit.MovieID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+			&credit.Movie.Title,
+			&credit.Movie.Year,
+			&credit.Movie.Runtime,
+			pq.Array(&credit.Movie.Genres),
+			&credit.Movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		credit.Movie.ID = credit.MovieID
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return credits, metadata, nil
+}
+
+// Delete removes a specific credit of a movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+	query := `
+		DELETE FROM credits
+		WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+var ErrDuplicateExternalID = errors.New("duplicate external id")
+
+// Namespaces of the external identifiers of movies.
+const (
+	ExternalIMDb     = "imdb"
+	ExternalTMDB     = "tmdb"
+	ExternalWikidata = "wikidata"
+)
+
+// ExternalNamespaces holds the namespaces of external identifiers, in the order
+// they're written in exports.
+var ExternalNamespaces = []string{ExternalIMDb, ExternalTMDB, ExternalWikidata}
+
+// externalIDRX holds the format of the identifiers in each namespace: IMDb title
+// IDs such as "tt0111161", TMDB movie IDs such as "278", and Wikidata item IDs
+// such as "Q172241".
+var externalIDRX = map[string]*regexp.Regexp{
+	ExternalIMDb:     regexp.MustCompile(`^tt[0-9]{7,10}$`),
+	ExternalTMDB:     regexp.MustCompile(`^[1-9][0-9]{0,9}$`),
+	ExternalWikidata: regexp.MustCompile(`^Q[1-9][0-9]{0,11}$`),
+}
+
+// externalIDsColumn selects the external identifiers of the movie in a query on
+// the movies table, as a JSON object that is scanned into ExternalIDs.
+const externalIDsColumn = `(SELECT jsonb_object_agg(namespace, value) FROM movie_external_ids WHERE movie_id = movies.id)`
+
+// ExternalIDs holds the identifiers of a movie in other databases, keyed by
+// namespace.
+type ExternalIDs map[string]string
+
+// NormalizeExternalID returns the namespace and identifier in the form they're
+// stored and compared in. Namespaces are case-insensitive, as are IMDb and
+// Wikidata identifiers.
+func NormalizeExternalID(namespace, value string) (string, string) {
+	namespace = strings.ToLower(strings.TrimSpace(namespace))
+	value = strings.TrimSpace(value)
+
+	switch namespace {
+	case ExternalIMDb:
+		value = strings.ToLower(value)
+	case ExternalWikidata:
+		value = strings.ToUpper(value)
+	}
+	return namespace, value
+}
+
+// NormalizeExternalIDs returns a copy of the identifiers with their namespaces
+// and values normalized. Nil identifiers stay nil.
+func NormalizeExternalIDs(ids ExternalIDs) ExternalIDs {
+	if ids == nil {
+		return nil
+	}
+
+	normalized := make(ExternalIDs, len(ids))
+	for namespace, value := range ids {
+		namespace, value = NormalizeExternalID(namespace, value)
+		normalized[namespace] = value
+	}
+	return normalized
+}
+
+// ValidExternalID reports whether the normalized identifier is well-formed for
+// its namespace, which must be a known one.
+func ValidExternalID(namespace, value string) bool {
+	rx, ok := externalIDRX[namespace]
+	return ok && validator.Matches(value, rx)
+}
+
+// ValidateExternalIDs checks the external identifiers of a movie, which must
+// already be normalized.
+func ValidateExternalIDs(v *validator.Validator, ids ExternalIDs) {
+	for _, namespace := range ids.namespaces() {
+		if _, ok := externalIDRX[namespace]; !ok {
+			v.AddError("external_ids", fmt.Sprintf("must only contain ids in the %s namespaces", strings.Join(ExternalNamespaces, ", ")))
+			continue
+		}
+		v.Check(ValidExternalID(namespace, ids[namespace]), "external_ids", fmt.Sprintf("must contain a valid %s id", namespace))
+	}
+}
+
+// namespaces returns the namespaces of the identifiers in sorted order, so that
+// they're checked and stored in a predictable order.
+func (ids ExternalIDs) namespaces() []string {
+	namespaces := make([]string, 0, len(ids))
+	for namespace := range ids {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Scan implements the sql.Scanner interface, reading the identifiers from the
+// JSON object selected by externalIDsColumn. A movie without identifiers has
+// an empty set of them.
+func (ids *ExternalIDs) Scan(src interface{}) error {
+	*ids = ExternalIDs{}
+
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, ids)
+	case string:
+		return json.Unmarshal([]byte(src), ids)
+	}
+	return fmt.Errorf("cannot scan %T into ExternalIDs", src)
+}
+
+// saveExternalIDs replaces the external identifiers of the movie, as part of the
+// transaction that inserts or updates it. Nil identifiers are left unchanged.
+func saveExternalIDs(ctx context.Context, tx *sql.Tx, movie *Movie) error {
+	if movie.ExternalIDs == nil {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM movie_external_ids WHERE movie_id = $1`, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_external_ids (movie_id, namespace, value)
+		VALUES ($1, $2, $3)`
+
+	for _, namespace := range movie.ExternalIDs.namespaces() {
+		_, err := tx.ExecContext(ctx, query, movie.ID, namespace, movie.ExternalIDs[namespace])
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "movie_external_ids_namespace_value_key"`:
+				return ErrDuplicateExternalID
+			default:
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertExternalIDs inserts the external identifiers of a batch of new movies, as
+// part of a transaction.
+func insertExternalIDs(ctx context.Context, tx *sql.Tx, movies []*Movie) error {
+	var ids []int64
+	var namespaces, values []string
+	for _, movie := range movies {
+		for _, namespace := range movie.ExternalIDs.namespaces() {
+			ids = append(ids, movie.ID)
+			namespaces = append(namespaces, namespace)
+			values = append(values, movie.ExternalIDs[namespace])
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO movie_external_ids (movie_id, namespace, value)
+		SELECT * FROM unnest($1::bigint[], $2::text[], $3::text[])`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(ids), pq.Array(namespaces), pq.Array(values))
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_external_ids_namespace_value_key"`:
+			return ErrDuplicateExternalID
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExternalIDOwner identifies the movie that an external identifier belongs to.
+type ExternalIDOwner struct {
+	Namespace string
+	Value     string
+	MovieID   int64
+	Trashed   bool
+}
+
+// GetExternalIDOwners returns the movies that any of the identifiers belong to,
+// including movies in the trash, ordered by namespace.
+func (m MovieModel) GetExternalIDOwners(ids ExternalIDs) ([]ExternalIDOwner, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	namespaces := ids.namespaces()
+	values := make([]string, len(namespaces))
+	for i, namespace := range namespaces {
+		values[i] = ids[namespace]
+	}
+
+	query := `
+		SELECT e.namespace, e.value, e.movie_id, m.deleted_at IS NOT NULL
+		FROM movie_external_ids e
+			INNER JOIN movies m ON m.id = e.movie_id
+		WHERE (e.namespace, e.value) IN (SELECT * FROM unnest($1::text[], $2::text[]))
+		ORDER BY e.namespace`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(namespaces), pq.Array(values))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owners []ExternalIDOwner
+
+	for rows.Next() {
+		var owner ExternalIDOwner
+		err := rows.Scan(&owner.Namespace, &owner.Value, &owner.MovieID, &owner.Trashed)
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return owners, nil
+}
+
+// GetIDForExternalID returns the ID of the movie that the normalized external
+// identifier belongs to. Movies in the trash aren't found.
+func (m MovieModel) GetIDForExternalID(namespace, value string) (int64, error) {
+	if !ValidExternalID(namespace, value) {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT e.movie_id
+		FROM movie_external_ids e
+			INNER JOIN movies m ON m.id = e.movie_id
+		WHERE e.namespace = $1 AND e.value = $2 AND m.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query, namespace, value).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
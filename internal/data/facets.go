@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// Facets that can be requested for a movie listing.
+const (
+	FacetGenres        = "genres"
+	FacetDecade        = "decade"
+	FacetRuntimeBucket = "runtime_bucket"
+)
+
+// FacetSafelist holds the permitted facet names.
+var FacetSafelist = []string{FacetGenres, FacetDecade, FacetRuntimeBucket}
+
+// runtimeBuckets holds the upper bounds (exclusive) in minutes of the runtime
+// buckets, in ascending order. Runtimes above the last bound fall in an open
+// ended bucket.
+var runtimeBuckets = []int{90, 120, 150}
+
+// FacetCount holds the number of movies with a specific facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.In(facet, FacetSafelist...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// facetQuery returns the SQL query selecting a value and a count per group for
+// the named facet, over the movies matching the where condition.
+func facetQuery(facet, where string) string {
+	switch facet {
+	case FacetGenres:
+		return `
+			SELECT genre, count(*)
+			FROM movies CROSS JOIN LATERAL unnest(genres) AS g(genre)
+			WHERE ` + where + `
+			GROUP BY genre
+			ORDER BY count(*) DESC, genre ASC`
+
+	case FacetDecade:
+		return `
+			SELECT ((year / 10) * 10)::text || 's', count(*)
+			FROM movies
+			WHERE ` + where + `
+			GROUP BY year / 10
+			ORDER BY year / 10 ASC`
+
+	case FacetRuntimeBucket:
+		// Build a CASE expression labelling each runtime with its bucket, along
+		// with the bucket's position for ordering.
+		var label, position strings.Builder
+		lower := 0
+		for i, upper := range runtimeBuckets {
+			fmt.Fprintf(&label, " WHEN runtime < %d THEN '%d-%d'", upper, lower, upper-1)
+			fmt.Fprintf(&position, " WHEN runtime < %d THEN %d", upper, i)
+			lower = upper
+		}
+		fmt.Fprintf(&label, " ELSE '%d+'", lower)
+		fmt.Fprintf(&position, " ELSE %d", len(runtimeBuckets))
+
+		return fmt.Sprintf(`
+			SELECT CASE%s END, count(*)
+			FROM movies
+			WHERE %s
+			GROUP BY 1, CASE%s END
+			ORDER BY CASE%s END ASC`, label.String(), where, position.String(), position.String())
+	}
+
+	panic("unknown facet: " + facet)
+}
+
+// GetFacets returns the number of movies per value of each of the named facets,
+// over the movies selected by the query. The title of a fuzzy query falls back
+// to trigram similarity under the same rule as GetAll, so that the counts match
+// the listing.
+func (m MovieModel) GetFacets(q MovieQuery, facets []string) (map[string][]FacetCount, error) {
+	fallback, err := m.fuzzyFallback(q)
+	if err != nil {
+		return nil, err
+	}
+	q.trigram = fallback
+
+	where, args := q.condition()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result := make(map[string][]FacetCount, len(facets))
+
+	for _, facet := range facets {
+		rows, err := m.DB.QueryContext(ctx, facetQuery(facet, where), args...)
+		if err != nil {
+			return nil, err
+		}
+
+		counts := []FacetCount{}
+		for rows.Next() {
+			var count FacetCount
+			err := rows.Scan(&count.Value, &count.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts = append(counts, count)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		result[facet] = counts
+	}
+
+	return result, nil
+}
@@ -1,17 +1,38 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"math"
 	"strings"
 
 	"github.com/lsjoeberg/greenlight/internal/validator"
 )
 
+// Total modes control how the total number of records is reported in Metadata.
+const (
+	TotalExact    = "exact"
+	TotalEstimate = "estimate"
+	TotalNone     = "none"
+)
+
+// TotalSafelist holds the permitted values for the Filters Total field.
+var TotalSafelist = []string{TotalExact, TotalEstimate, TotalNone}
+
+// Filters holds the pagination, sorting and field selection parameters of a
+// listing. Sort is a comma-separated list of columns from SortSafelist, each
+// optionally prefixed with "-" for descending order. If Fields is empty, all
+// fields are included.
 type Filters struct {
-	Page         int
-	PageSize     int
-	Sort         string
-	SortSafelist []string
+	Page          int
+	PageSize      int
+	Sort          string
+	SortSafelist  []string
+	Cursor        string
+	Total         string
+	Fields        []string
+	FieldSafelist []string
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -21,58 +42,222 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
-}
+	// Check that each sort key matches a value in the safelist, and that no column
+	// is sorted by more than once.
+	keys := strings.Split(f.Sort, ",")
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		v.Check(validator.In(key, f.SortSafelist...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(key, "-")
+	}
+	v.Check(len(keys) <= 3, "sort", "must not contain more than 3 sort keys")
+	v.Check(validator.Unique(columns), "sort", "must not contain duplicate sort columns")
+
+	// Check that the total parameter matches a value in the safelist.
+	v.Check(validator.In(f.Total, TotalSafelist...), "total", "invalid total value")
+
+	// Check that each selected field matches a value in the safelist.
+	for _, field := range f.Fields {
+		v.Check(validator.In(field, f.FieldSafelist...), "fields", "invalid field value")
+	}
+	v.Check(validator.Unique(f.Fields), "fields", "must not contain duplicate values")
 
-// sortColumn compares client-provided Sort field with permissible values.
-// If the parameter value is valid, it's extracted and returned.
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+	// A cursor replaces the page parameter, and is only valid for the sort order
+	// that it was issued for.
+	if f.Cursor != "" {
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor value")
+			return
 		}
+		v.Check(c.Sort == f.Sort && len(c.Values) == len(keys), "cursor", "must be used with the same sort value it was issued for")
 	}
-	panic("unsafe sort parameter: " + f.Sort)
 }
 
-// sortDirection returns the sort direction ("ASC" or "DESC") depending on the
-// prefix character of the Sort field.
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+// sortKey holds a single column of a sort order.
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// direction returns the SQL sort direction of the key.
+func (k sortKey) direction() string {
+	if k.desc {
 		return "DESC"
 	}
 	return "ASC"
 }
 
+// sortKeys compares the client-provided Sort field with permissible values, and
+// returns the sort keys in order. It panics if any key is not in the safelist.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+
+	for _, key := range strings.Split(f.Sort, ",") {
+		if !validator.In(key, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + key)
+		}
+
+		column := strings.TrimPrefix(key, "-")
+		keys = append(keys, sortKey{
+			column: column,
+			// Relevance is always sorted with the best match first.
+			desc: strings.HasPrefix(key, "-") || column == "relevance",
+		})
+	}
+
+	return keys
+}
+
+// orderBy returns the list of SQL sort expressions for the sort keys. The
+// expressions map holds the SQL expressions for sort columns that are not
+// plain table columns.
+func (f Filters) orderBy(expressions map[string]string) string {
+	var terms []string
+	for _, key := range f.sortKeys() {
+		terms = append(terms, sortExpression(key.column, expressions)+" "+key.direction())
+	}
+	return strings.Join(terms, ", ")
+}
+
+// sortExpression returns the SQL expression for a sort column.
+func sortExpression(column string, expressions map[string]string) string {
+	if expression, ok := expressions[column]; ok {
+		return expression
+	}
+	return column
+}
+
+// selects reports whether the column is needed for a listing, because it's one
+// of the selected fields or one of the sort columns. The expressions map is the
+// same as for orderBy, so a sort column that is sorted by a table column, such as
+// "rating" by "average_rating", selects that column.
+func (f Filters) selects(column string, expressions map[string]string) bool {
+	if len(f.Fields) == 0 || validator.In(column, f.Fields...) {
+		return true
+	}
+	for _, key := range f.sortKeys() {
+		if sortExpression(key.column, expressions) == column {
+			return true
+		}
+	}
+	return false
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
 
 func (f Filters) offset() int {
+	// Keyset pagination always starts right after the cursor position.
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
+// keysetCondition returns a SQL condition matching the rows that come after the
+// cursor position in the current sort order, using id as the final tie-breaker.
+// The expressions map is the same as for orderBy. The cursor values are appended
+// to args, and the placeholders in the condition are numbered accordingly.
+func (f Filters) keysetCondition(c cursor, expressions map[string]string, args []interface{}) (string, []interface{}) {
+	// A row comes after the cursor if it's after it in the first sort column, or
+	// equal in the first column and after it in the second, and so on.
+	var alternatives []string
+	var equal []string
+
+	for i, key := range f.sortKeys() {
+		expression := sortExpression(key.column, expressions)
+
+		op := ">"
+		if key.desc {
+			op = "<"
+		}
+
+		args = append(args, c.Values[i])
+		placeholder := fmt.Sprintf("$%d", len(args))
+
+		alternatives = append(alternatives, strings.Join(append(equal, expression+" "+op+" "+placeholder), " AND "))
+		equal = append(equal, expression+" = "+placeholder)
+	}
+
+	args = append(args, c.ID)
+	alternatives = append(alternatives, strings.Join(append(equal, fmt.Sprintf("id > $%d", len(args))), " AND "))
+
+	return "((" + strings.Join(alternatives, ") OR (") + "))", args
+}
+
+// cursor holds the position of the last record on a page, for keyset pagination.
+// It is handed to clients as an opaque base64-encoded string.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     int64    `json:"i"`
+}
+
+// encode returns the opaque string representation of the cursor.
+func (c cursor) encode() string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor parses an opaque cursor string created by cursor.encode.
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	return c, err
+}
+
 // Metadata holds the pagination metadata.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage    int    `json:"current_page,omitempty"`
+	PageSize       int    `json:"page_size,omitempty"`
+	FirstPage      int    `json:"first_page,omitempty"`
+	LastPage       int    `json:"last_page,omitempty"`
+	TotalRecords   int    `json:"total_records,omitempty"`
+	TotalEstimated bool   `json:"total_estimated,omitempty"`
+	NextCursor     string `json:"next_cursor,omitempty"`
+	FuzzyMatch     bool   `json:"fuzzy_match,omitempty"`
 }
 
 // calculateMetadata calculates the appropriate pagination metadata values
-// given the total number of records, current page, and page size values.
-func calculateMetadata(totalRecords, page, pageSize int) Metadata {
-	if totalRecords == 0 {
+// given the total number of records, the filters, and the cursor for the next
+// page of records, if any.
+func calculateMetadata(totalRecords int, filters Filters, nextCursor string) Metadata {
+	if totalRecords == 0 && nextCursor == "" && filters.Total != TotalNone {
 		return Metadata{}
 	}
-	return Metadata{
-		CurrentPage:  page,
-		PageSize:     pageSize,
-		FirstPage:    1,
-		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
-		TotalRecords: totalRecords,
+
+	metadata := Metadata{
+		PageSize:   filters.PageSize,
+		NextCursor: nextCursor,
+	}
+
+	if filters.Total != TotalNone {
+		metadata.TotalRecords = totalRecords
+		metadata.TotalEstimated = filters.Total == TotalEstimate
+	}
+
+	// Page numbers have no meaning when paginating with a cursor.
+	if filters.Cursor != "" {
+		return metadata
 	}
+
+	metadata.CurrentPage = filters.Page
+	metadata.FirstPage = 1
+	if filters.Total != TotalNone && totalRecords > 0 {
+		metadata.LastPage = int(math.Ceil(float64(totalRecords) / float64(filters.PageSize)))
+	}
+
+	return metadata
 }
@@ -0,0 +1,350 @@
+package data
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// SlugRX matches canonical genre slugs: lowercase words of letters and digits,
+// separated by single hyphens.
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
+// Genre represents a genre in the vocabulary. Movies refer to genres by their
+// canonical slug, while the synonyms are alternative spellings which are mapped
+// to the slug.
+type Genre struct {
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Synonyms  []string  `json:"synonyms"`
+	Version   int32     `json:"version"`
+}
+
+// GenreKey returns the normalized form of a genre name, in which it's compared
+// to slugs and synonyms: lowercase, with runs of other characters than a-z and
+// 0-9 replaced by a single hyphen. Names without any such characters, such as
+// non-ASCII names, are keyed by a hash of the name instead, in the same way as
+// when the genres table was created. Only blank names have an empty key.
+func GenreKey(name string) string {
+	key := asciiGenreKey(name)
+	if key == "" && strings.TrimSpace(name) != "" {
+		sum := md5.Sum([]byte(strings.ToLower(strings.TrimSpace(name))))
+		key = "genre-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return key
+}
+
+// asciiGenreKey returns the name in lowercase, with runs of other characters
+// than a-z and 0-9 replaced by a single hyphen.
+func asciiGenreKey(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+// ValidateGenre checks a genre on its own. Conflicts with the slugs and synonyms
+// of other genres are checked against the vocabulary with ValidateGenreSynonyms.
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 50, "slug", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(genre.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(genre.Synonyms != nil, "synonyms", "must be provided")
+	v.Check(len(genre.Synonyms) <= 20, "synonyms", "must not contain more than 20 synonyms")
+	v.Check(validator.Unique(genre.Synonyms), "synonyms", "must not contain duplicate values")
+	for _, synonym := range genre.Synonyms {
+		v.Check(validator.Matches(synonym, SlugRX), "synonyms", "must only contain lowercase letters, digits and single hyphens")
+		v.Check(synonym != genre.Slug, "synonyms", "must not contain the slug")
+	}
+}
+
+// GenreVocabulary maps the slugs and synonyms of all genres to their canonical
+// slugs.
+type GenreVocabulary map[string]string
+
+// Normalize returns the canonical slugs of the genres, in the same order and
+// without duplicates. Unknown genres are kept as they are, so that they're
+// reported by ValidateMovie.
+func (gv GenreVocabulary) Normalize(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	normalized := []string{}
+	for _, genre := range genres {
+		if slug, ok := gv[GenreKey(genre)]; ok {
+			genre = slug
+		}
+		if !validator.In(genre, normalized...) {
+			normalized = append(normalized, genre)
+		}
+	}
+	return normalized
+}
+
+// Known reports whether the genre is a canonical slug.
+func (gv GenreVocabulary) Known(genre string) bool {
+	return gv[genre] == genre
+}
+
+// ValidateGenreSynonyms checks that the slug and synonyms of a genre aren't used
+// by any other genre in the vocabulary.
+func ValidateGenreSynonyms(v *validator.Validator, genre *Genre, vocabulary GenreVocabulary) {
+	if slug, ok := vocabulary[genre.Slug]; ok && slug != genre.Slug {
+		v.AddError("slug", "is already a synonym of the "+slug+" genre")
+	}
+	for _, synonym := range genre.Synonyms {
+		if slug, ok := vocabulary[synonym]; ok && slug != genre.Slug {
+			v.AddError("synonyms", synonym+" is already used by the "+slug+" genre")
+		}
+	}
+}
+
+// vocabularyTTL is how long the vocabulary is cached. Changes made through the
+// model invalidate the cache right away, while changes made by other instances
+// of the API are picked up when it expires.
+const vocabularyTTL = time.Minute
+
+// vocabularyCache holds the vocabulary between requests, as it's needed by most
+// movie requests but rarely changes.
+type vocabularyCache struct {
+	mu         sync.Mutex
+	vocabulary GenreVocabulary
+	expires    time.Time
+}
+
+// GenreModel represents a model of the genres store.
+type GenreModel struct {
+	DB    *sql.DB
+	cache *vocabularyCache
+}
+
+// Vocabulary returns the vocabulary of all genres. The vocabulary is shared
+// between callers, and must not be modified.
+func (m GenreModel) Vocabulary() (GenreVocabulary, error) {
+	if m.cache != nil {
+		m.cache.mu.Lock()
+		defer m.cache.mu.Unlock()
+
+		if m.cache.vocabulary != nil && time.Now().Before(m.cache.expires) {
+			return m.cache.vocabulary, nil
+		}
+	}
+
+	genres, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	vocabulary := make(GenreVocabulary)
+	for _, genre := range genres {
+		vocabulary[genre.Slug] = genre.Slug
+		for _, synonym := range genre.Synonyms {
+			vocabulary[synonym] = genre.Slug
+		}
+	}
+
+	if m.cache != nil {
+		m.cache.vocabulary = vocabulary
+		m.cache.expires = time.Now().Add(vocabularyTTL)
+	}
+
+	return vocabulary, nil
+}
+
+// invalidate drops the cached vocabulary, after the genres have been changed.
+func (m GenreModel) invalidate() {
+	if m.cache == nil {
+		return
+	}
+
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+
+	m.cache.vocabulary = nil
+}
+
+// Insert inserts a new record in the genres table.
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+		INSERT INTO genres (slug, name, synonyms)
+		VALUES ($1, $2, $3)
+		RETURNING created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, genre.Slug, genre.Name, pq.Array(genre.Synonyms)).Scan(
+		&genre.CreatedAt,
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_pkey"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	m.invalidate()
+
+	return nil
+}
+
+// Get fetches a specific record from the genres table.
+func (m GenreModel) Get(slug string) (*Genre, error) {
+	query := `
+		SELECT slug, created_at, name, synonyms, version
+		FROM genres
+		WHERE slug = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var genre Genre
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&genre.Slug,
+		&genre.CreatedAt,
+		&genre.Name,
+		pq.Array(&genre.Synonyms),
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// GetAll returns all genres, ordered by name. The vocabulary is small enough
+// not to need pagination.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT slug, created_at, name, synonyms, version
+		FROM genres
+		ORDER BY name ASC, slug ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(
+			&genre.Slug,
+			&genre.CreatedAt,
+			&genre.Name,
+			pq.Array(&genre.Synonyms),
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Update updates the name and synonyms of a genre, checking for edit conflicts
+// with the version of the genre. The slug can't be changed.
+func (m GenreModel) Update(genre *Genre) error {
+	query := `
+		UPDATE genres SET name = $1, synonyms = $2, version = version + 1
+		WHERE slug = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{genre.Name, pq.Array(genre.Synonyms), genre.Slug, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	m.invalidate()
+
+	return nil
+}
+
+// Delete removes a specific record from the genres table. Genres which are used
+// by any movie, including those in the trash, can't be deleted.
+func (m GenreModel) Delete(slug string) error {
+	query := `
+		DELETE FROM genres
+		WHERE slug = $1 AND NOT EXISTS (SELECT 1 FROM movies WHERE genres @> ARRAY[$1])
+		RETURNING slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&slug)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		// Tell a missing genre apart from one which is in use.
+		_, err = m.Get(slug)
+		if err != nil {
+			return err
+		}
+		return ErrGenreInUse
+	}
+
+	m.invalidate()
+
+	return nil
+}
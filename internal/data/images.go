@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Image kinds.
+const (
+	ImagePoster   = "poster"
+	ImageBackdrop = "backdrop"
+)
+
+// MovieImage holds the metadata of an image attached to a movie. The image and
+// its thumbnail are kept in object storage, under keys derived from the movie,
+// the kind and the checksum of the image.
+type MovieImage struct {
+	MovieID     int64     `json:"movie_id"`
+	Kind        string    `json:"kind"`
+	CreatedAt   time.Time `json:"created_at"`
+	ContentType string    `json:"content_type"`
+	Width       int32     `json:"width"`
+	Height      int32     `json:"height"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`
+}
+
+// MovieImagesPrefix returns the storage key prefix of all images of a movie.
+func MovieImagesPrefix(movieID int64) string {
+	return fmt.Sprintf("movies/%d", movieID)
+}
+
+// Key returns the storage key of the image.
+func (img *MovieImage) Key() string {
+	return fmt.Sprintf("%s/%s-%s", MovieImagesPrefix(img.MovieID), img.Kind, img.Checksum)
+}
+
+// ThumbnailKey returns the storage key of the thumbnail of the image.
+func (img *MovieImage) ThumbnailKey() string {
+	return img.Key() + "-thumbnail"
+}
+
+// MovieImageModel represents a model of the movie images store.
+type MovieImageModel struct {
+	DB *sql.DB
+}
+
+// Get fetches the image of a specific kind for a movie. Images of movies in the
+// trash are treated as not found.
+func (m MovieImageModel) Get(movieID int64, kind string) (*MovieImage, error) {
+	query := `
+		SELECT movie_images.movie_id, movie_images.kind, movie_images.created_at, movie_images.content_type,
+			movie_images.width, movie_images.height, movie_images.size, movie_images.checksum
+		FROM movie_images
+			INNER JOIN movies ON movies.id = movie_images.movie_id
+		WHERE movie_images.movie_id = $1 AND movie_images.kind = $2 AND movies.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var img MovieImage
+	err := m.DB.QueryRowContext(ctx, query, movieID, kind).Scan(
+		&img.MovieID,
+		&img.Kind,
+		&img.CreatedAt,
+		&img.ContentType,
+		&img.Width,
+		&img.Height,
+		&img.Size,
+		&img.Checksum,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &img, nil
+}
+
+// Save inserts or replaces the image of its kind for the movie.
+func (m MovieImageModel) Save(img *MovieImage) error {
+	query := `
+		INSERT INTO movie_images (movie_id, kind, content_type, width, height, size, checksum)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (movie_id, kind) DO UPDATE
+		SET created_at = NOW(), content_type = EXCLUDED.content_type, width = EXCLUDED.width,
+			height = EXCLUDED.height, size = EXCLUDED.size, checksum = EXCLUDED.checksum
+		RETURNING created_at`
+
+	args := []interface{}{img.MovieID, img.Kind, img.ContentType, img.Width, img.Height, img.Size, img.Checksum}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&img.CreatedAt)
+}
+
+// Delete removes the image of a specific kind for a movie.
+func (m MovieImageModel) Delete(movieID int64, kind string) error {
+	query := `
+		DELETE FROM movie_images
+		WHERE movie_id = $1 AND kind = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, kind)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,588 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+var ErrDuplicateListEntry = errors.New("duplicate list entry")
+
+// List represents a named, ordered list of movies owned by a user. Each user has
+// a private watchlist, and any number of custom lists which may be public.
+type List struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UserID       int64     `json:"user_id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description,omitempty"`
+	Public       bool      `json:"public"`
+	Watchlist    bool      `json:"watchlist"`
+	EntriesCount int32     `json:"entries_count"`
+	Version      int32     `json:"version"`
+}
+
+// ListEntry represents a movie in a list. Entries are ordered by position,
+// starting at 1.
+type ListEntry struct {
+	ListID   int64     `json:"-"`
+	MovieID  int64     `json:"movie_id"`
+	AddedAt  time.Time `json:"added_at"`
+	Position int32     `json:"position"`
+	Note     string    `json:"note,omitempty"`
+	Movie    *Movie    `json:"movie,omitempty"`
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(list.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+
+	v.Check(!(list.Watchlist && list.Public), "public", "the watchlist must be private")
+}
+
+// ValidateListEntry checks a list entry. A zero position places the entry at
+// the end of the list.
+func ValidateListEntry(v *validator.Validator, entry *ListEntry) {
+	v.Check(entry.MovieID != 0, "movie_id", "must be provided")
+	v.Check(entry.MovieID >= 0, "movie_id", "must be a positive integer")
+
+	v.Check(entry.Position >= 0, "position", "must be a positive integer")
+
+	v.Check(len(entry.Note) <= 2000, "note", "must not be more than 2000 bytes long")
+}
+
+// ListModel represents a model of the lists store.
+type ListModel struct {
+	DB *sql.DB
+}
+
+// listColumns are the columns selected for a List, in the order scanned by scanList.
+const listColumns = `lists.id, lists.created_at, lists.user_id, lists.name, lists.description,
+		lists.public, lists.watchlist, lists.version,
+		(SELECT count(*) FROM list_entries INNER JOIN movies ON movies.id = list_entries.movie_id
+			WHERE list_entries.list_id = lists.id AND movies.deleted_at IS NULL)`
+
+// scanList returns the arguments for scanning listColumns into the list.
+func scanList(list *List) []interface{} {
+	return []interface{}{
+		&list.ID,
+		&list.CreatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Description,
+		&list.Public,
+		&list.Watchlist,
+		&list.Version,
+		&list.EntriesCount,
+	}
+}
+
+// Insert inserts a new custom list.
+func (m ListModel) Insert(list *List) error {
+	query := `
+		INSERT INTO lists (user_id, name, description, public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{list.UserID, list.Name, list.Description, list.Public}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.Version)
+}
+
+// Get fetches a specific list.
+func (m ListModel) Get(id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + listColumns + `
+		FROM lists
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list List
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(scanList(&list)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+// GetWatchlist fetches the watchlist of a specific user, creating it if the user
+// doesn't have one yet.
+func (m ListModel) GetWatchlist(userID int64) (*List, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO lists (user_id, name, watchlist)
+		VALUES ($1, 'Watchlist', true)
+		ON CONFLICT (user_id) WHERE watchlist DO NOTHING`
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `SELECT ` + listColumns + `
+		FROM lists
+		WHERE user_id = $1 AND watchlist`
+
+	var list List
+	err = m.DB.QueryRowContext(ctx, query, userID).Scan(scanList(&list)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// GetAllForUser returns a slice of the lists owned by a specific user.
+func (m ListModel) GetAllForUser(userID int64, filters Filters) ([]*List, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM lists
+		WHERE user_id = $1
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, listColumns, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	lists := []*List{}
+
+	for rows.Next() {
+		var list List
+		err := rows.Scan(append([]interface{}{&totalRecords}, scanList(&list)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return lists, metadata, nil
+}
+
+// Update updates the name, description and visibility of a list, checking for
+// edit conflicts with the version of the list.
+func (m ListModel) Update(list *List) error {
+	query := `
+		UPDATE lists SET name = $1, description = $2, public = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{list.Name, list.Description, list.Public, list.ID, list.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a custom list together with its entries. Watchlists can't be
+// deleted.
+func (m ListModel) Delete(id int64) error {
+	query := `
+		DELETE FROM lists
+		WHERE id = $1 AND NOT watchlist`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetEntries returns a slice of the entries of a specific list, along with their
+// movies. Movies in the trash are left out, and don't count towards the positions.
+// The positions are counted when the entries are read, so the entries of movies
+// in the trash get their place in the list back when the movies are restored.
+func (m ListModel) GetEntries(listID int64, filters Filters) ([]*ListEntry, Metadata, error) {
+	sortExpressions := map[string]string{
+		"position": "list_entries.position",
+		"added_at": "list_entries.created_at",
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), list_entries.movie_id, list_entries.created_at,
+			row_number() OVER (ORDER BY list_entries.position, list_entries.movie_id),
+			list_entries.note, movies.title, movies.year, movies.runtime, movies.genres, movies.version
+		FROM list_entries
+			INNER JOIN movies ON movies.id = list_entries.movie_id
+		WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s, list_entries.movie_id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy(sortExpressions))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*ListEntry{}
+
+	for rows.Next() {
+		entry := ListEntry{ListID: listID, Movie: &Movie{}}
+		err := rows.Scan(
+			&totalRecords,
+			&entry.MovieID,
+			&entry.AddedAt,
+			&entry.Position,
+			&entry.Note,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entry.Movie.ID = entry.MovieID
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return entries, metadata, nil
+}
+
+// GetEntry fetches a specific entry of a list, without its movie. Entries of
+// movies in the trash aren't found, like with GetEntries.
+func (m ListModel) GetEntry(listID, movieID int64) (*ListEntry, error) {
+	query := `
+		SELECT created_at, position, note
+		FROM (` + listEntryPositions + `) AS entries
+		WHERE movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	entry := ListEntry{ListID: listID, MovieID: movieID}
+	err := m.DB.QueryRowContext(ctx, query, listID, movieID).Scan(&entry.AddedAt, &entry.Position, &entry.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// AddEntry adds a movie to a list at the position of the entry, moving the
+// following entries down. A zero position, or one past the end of the list,
+// adds the movie at the end.
+func (m ListModel) AddEntry(entry *ListEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count, err := lockListEntries(ctx, tx, entry.ListID)
+	if err != nil {
+		return err
+	}
+
+	// Only movies which aren't in the trash can be added.
+	err = tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL`, entry.MovieID).Scan(&entry.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if entry.Position == 0 || entry.Position > count {
+		entry.Position = count + 1
+	}
+
+	// The movie takes the place of the entry at its position, or goes after all
+	// entries, including those of movies in the trash, at the end of the list.
+	var stored int32
+	if entry.Position <= count {
+		stored, err = storedListPosition(ctx, tx, entry.ListID, entry.Position)
+	} else {
+		query := `SELECT coalesce(max(position), 0) + 1 FROM list_entries WHERE list_id = $1`
+		err = tx.QueryRowContext(ctx, query, entry.ListID).Scan(&stored)
+	}
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE list_entries SET position = position + 1
+		WHERE list_id = $1 AND position >= $2`
+
+	_, err = tx.ExecContext(ctx, query, entry.ListID, stored)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO list_entries (list_id, movie_id, position, note)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+
+	args := []interface{}{entry.ListID, entry.MovieID, stored, entry.Note}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "list_entries_pkey"`:
+			return ErrDuplicateListEntry
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateEntry updates the note of a list entry, and moves it to the position of
+// the entry. A zero position leaves the entry in place, and a position past the
+// end of the list moves it to the end.
+func (m ListModel) UpdateEntry(entry *ListEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count, err := lockListEntries(ctx, tx, entry.ListID)
+	if err != nil {
+		return err
+	}
+
+	// Only entries of movies which aren't in the trash have a position to move.
+	var current, currentStored int32
+	query := `
+		SELECT position, stored
+		FROM (` + listEntryPositions + `) AS entries
+		WHERE movie_id = $2`
+	err = tx.QueryRowContext(ctx, query, entry.ListID, entry.MovieID).Scan(&current, &currentStored)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if entry.Position == 0 {
+		entry.Position = current
+	}
+	if entry.Position > count {
+		entry.Position = count
+	}
+
+	stored := currentStored
+	if entry.Position != current {
+		stored, err = storedListPosition(ctx, tx, entry.ListID, entry.Position)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Shift the entries between the current and the new place one step towards
+	// the current place, including those of movies in the trash.
+	switch {
+	case stored < currentStored:
+		query = `
+			UPDATE list_entries SET position = position + 1
+			WHERE list_id = $1 AND position >= $2 AND position < $3`
+	case stored > currentStored:
+		query = `
+			UPDATE list_entries SET position = position - 1
+			WHERE list_id = $1 AND position > $3 AND position <= $2`
+	}
+	if stored != currentStored {
+		_, err = tx.ExecContext(ctx, query, entry.ListID, stored, currentStored)
+		if err != nil {
+			return err
+		}
+	}
+
+	query = `
+		UPDATE list_entries SET position = $3, note = $4
+		WHERE list_id = $1 AND movie_id = $2
+		RETURNING created_at`
+
+	args := []interface{}{entry.ListID, entry.MovieID, stored, entry.Note}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveEntry removes a movie from a list, moving the following entries up.
+func (m ListModel) RemoveEntry(listID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockListEntries(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	var position int32
+	query := `
+		DELETE FROM list_entries
+		WHERE list_id = $1 AND movie_id = $2
+		RETURNING position`
+
+	err = tx.QueryRowContext(ctx, query, listID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE list_entries SET position = position - 1
+		WHERE list_id = $1 AND position > $2`
+
+	_, err = tx.ExecContext(ctx, query, listID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// listEntryPositions selects the entries of the list in $1 whose movies aren't in
+// the trash, with their positions counted without those that are. The stored
+// positions order all the entries, so that the entries of movies in the trash
+// keep their place in the list, but may have gaps.
+const listEntryPositions = `
+		SELECT list_entries.movie_id, list_entries.created_at, list_entries.note,
+			list_entries.position AS stored,
+			row_number() OVER (ORDER BY list_entries.position, list_entries.movie_id) AS position
+		FROM list_entries
+			INNER JOIN movies ON movies.id = list_entries.movie_id
+		WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL`
+
+// storedListPosition returns the stored position of the entry at a position of
+// a list, as part of a transaction.
+func storedListPosition(ctx context.Context, tx *sql.Tx, listID int64, position int32) (int32, error) {
+	query := `
+		SELECT stored
+		FROM (` + listEntryPositions + `) AS entries
+		WHERE position = $2`
+
+	var stored int32
+	err := tx.QueryRowContext(ctx, query, listID, position).Scan(&stored)
+	return stored, err
+}
+
+// lockListEntries locks the list for the rest of the transaction, so that
+// concurrent changes to its entries are made one at a time, and returns the
+// number of entries, not counting movies in the trash like EntriesCount.
+func lockListEntries(ctx context.Context, tx *sql.Tx, listID int64) (int32, error) {
+	query := `SELECT id FROM lists WHERE id = $1 FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, listID).Scan(&listID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	query = `
+		SELECT count(*)
+		FROM list_entries
+			INNER JOIN movies ON movies.id = list_entries.movie_id
+		WHERE list_entries.list_id = $1 AND movies.deleted_at IS NULL`
+
+	var count int32
+	err = tx.QueryRowContext(ctx, query, listID).Scan(&count)
+	return count, err
+}
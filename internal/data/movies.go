@@ -3,9 +3,13 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/lsjoeberg/greenlight/internal/validator"
@@ -18,19 +22,111 @@ var (
 
 // Movie represents a movie entity.
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID            int64        `json:"id"`
+	CreatedAt     time.Time    `json:"-"`
+	UpdatedAt     time.Time    `json:"-"`
+	Title         string       `json:"title"`
+	Titles        []MovieTitle `json:"titles,omitempty"`
+	ExternalIDs   ExternalIDs  `json:"external_ids,omitempty"`
+	Year          int32        `json:"year,omitempty"`
+	Runtime       Runtime      `json:"runtime,omitempty"`
+	Genres        []string     `json:"genres,omitempty"`
+	Version       int32        `json:"version"`
+	AverageRating float32      `json:"average_rating,omitempty"`
+	RatingsCount  int32        `json:"ratings_count,omitempty"`
+	DeletedAt     *time.Time   `json:"deleted_at,omitempty"`
+	Highlight     string       `json:"highlight,omitempty"`
+	// The search rank is only used for pagination cursors.
+	rank float32
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// sortValue returns the value of the given sort column for the movie, formatted
+// for use in a pagination cursor.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	case "rating":
+		return strconv.FormatFloat(float64(movie.AverageRating), 'g', -1, 32)
+	case "relevance":
+		return strconv.FormatFloat(float64(movie.rank), 'g', -1, 32)
+	}
+	panic("unknown sort column: " + column)
+}
+
+// SelectFields returns the named fields of the movie, keyed by their JSON
+// names, for responses with a sparse fieldset. The runtime is encoded in the
+// given format.
+func (movie *Movie) SelectFields(fields []string, format RuntimeFormat) map[string]interface{} {
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			selected[field] = movie.ID
+		case "title":
+			selected[field] = movie.Title
+		case "titles":
+			selected[field] = movie.Titles
+		case "external_ids":
+			selected[field] = movie.ExternalIDs
+		case "year":
+			selected[field] = movie.Year
+		case "runtime":
+			selected[field] = movie.Runtime.Formatted(format)
+		case "genres":
+			selected[field] = movie.Genres
+		case "version":
+			selected[field] = movie.Version
+		case "average_rating":
+			selected[field] = movie.AverageRating
+		case "ratings_count":
+			selected[field] = movie.RatingsCount
+		case "highlight":
+			selected[field] = movie.Highlight
+		default:
+			panic("unknown movie field: " + field)
+		}
+	}
+	return selected
+}
+
+// formattedMovie is a movie that's encoded to JSON with its runtime in a specific
+// format, which takes the place of the runtime of the movie.
+type formattedMovie struct {
+	*Movie
+	Runtime *FormattedRuntime `json:"runtime,omitempty"`
+}
+
+// WithRuntimeFormat returns the movie for encoding to JSON with its runtime in
+// the format. The movie itself is left as it is.
+func (movie *Movie) WithRuntimeFormat(format RuntimeFormat) interface{} {
+	if format == RuntimeFormatDefault {
+		return movie
+	}
+
+	fm := &formattedMovie{Movie: movie}
+	if movie.Runtime != 0 {
+		runtime := movie.Runtime.Formatted(format)
+		fm.Runtime = &runtime
+	}
+	return fm
+}
+
+// ValidateMovie checks a movie, whose genres must be canonical slugs from the
+// vocabulary.
+func ValidateMovie(v *validator.Validator, movie *Movie, vocabulary GenreVocabulary) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
+	ValidateMovieTitles(v, movie.Titles)
+	ValidateExternalIDs(v, movie.ExternalIDs)
+
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
 	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
@@ -42,22 +138,49 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	for _, genre := range movie.Genres {
+		v.Check(vocabulary.Known(genre), "genres", "must only contain known genres")
+	}
 }
 
 // Models wraps application storage models.
 type Models struct {
+	Changes     MovieChangeModel
+	Credits     CreditModel
+	Deliveries  WebhookDeliveryModel
+	Genres      GenreModel
+	Images      MovieImageModel
+	Lists       ListModel
 	Movies      MovieModel
+	People      PersonModel
 	Permissions PermissionModel
+	Ratings     RatingModel
+	Reviews     ReviewModel
+	Revisions   RevisionModel
+	Searches    SavedSearchModel
 	Tokens      TokenModel
 	Users       UserModel
+	Webhooks    WebhookModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
+		Changes:     MovieChangeModel{DB: db},
+		Credits:     CreditModel{DB: db},
+		Deliveries:  WebhookDeliveryModel{DB: db},
+		Genres:      GenreModel{DB: db, cache: &vocabularyCache{}},
+		Images:      MovieImageModel{DB: db},
+		Lists:       ListModel{DB: db},
 		Movies:      MovieModel{DB: db},
+		People:      PersonModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Ratings:     RatingModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Revisions:   RevisionModel{DB: db},
+		Searches:    SavedSearchModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Users:       UserModel{DB: db},
+		Webhooks:    WebhookModel{DB: db},
 	}
 }
 
@@ -66,8 +189,39 @@ type MovieModel struct {
 	DB *sql.DB
 }
 
-// Insert inserts a new record in the movies table.
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert inserts a new record in the movies table, and records it as the first
+// revision of the movie made by the given user.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	// Create a Context which carries a 3-second timeout deadline.
+	// Deferring cancel() ensures that the resources associated with our context
+	// will always be released before the Insert() method returns.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The movie and its revision are inserted in a single transaction. The deferred
+	// Rollback() is a no-op if the transaction has been committed.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEvent(ctx, tx, EventMovieCreated, moviePayload(movie))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertMovie inserts a new record in the movies table together with its first
+// revision, as part of a transaction.
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
 	query := `
 		INSERT INTO movies (title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
@@ -80,42 +234,237 @@ func (m MovieModel) Insert(movie *Movie) error {
 		pq.Array(movie.Genres),
 	}
 
-	// Create a Context which carries a 3-second timeout deadline.
-	// Deferring cancel() ensures that the resources associated with our context
-	// will always be released before the Get() method returns.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Version,
 	)
+	if err != nil {
+		return err
+	}
+
+	err = saveTitles(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	err = saveExternalIDs(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	return insertRevision(ctx, tx, movie, userID)
+}
+
+// moviePayload returns the payload of the webhook events about a movie.
+func moviePayload(movie *Movie) interface{} {
+	return map[string]interface{}{"movie": movie}
+}
+
+// moviePayloads returns the payloads of the webhook events about the movies.
+func moviePayloads(movies []*Movie) []interface{} {
+	payloads := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		payloads[i] = moviePayload(movie)
+	}
+	return payloads
+}
+
+// MovieImport inserts batches of movies within a single transaction, which may
+// span many more queries than the other MovieModel methods.
+type MovieImport struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+	userID int64
+}
+
+// BeginImport starts a transaction for importing movies on behalf of the given
+// user. The transaction must be ended with either Commit or Rollback.
+func (m MovieModel) BeginImport(userID int64) (*MovieImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &MovieImport{tx: tx, ctx: ctx, cancel: cancel, userID: userID}, nil
+}
+
+// Save saves a batch of movies in the import transaction. Movies without an ID
+// are inserted together, and those with one are updated like with Update.
+func (imp *MovieImport) Save(movies []*Movie) error {
+	var inserted, updated []*Movie
+	for _, movie := range movies {
+		if movie.ID == 0 {
+			inserted = append(inserted, movie)
+		} else {
+			updated = append(updated, movie)
+		}
+	}
+
+	err := insertMovies(imp.ctx, imp.tx, inserted, imp.userID)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range updated {
+		err := updateMovie(imp.ctx, imp.tx, movie, imp.userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = enqueueEvent(imp.ctx, imp.tx, EventMovieCreated, moviePayloads(inserted)...)
+	if err != nil {
+		return err
+	}
+
+	return enqueueEvent(imp.ctx, imp.tx, EventMovieUpdated, moviePayloads(updated)...)
+}
+
+// insertMovies inserts a batch of new records in the movies table together with
+// their first revisions, as part of a transaction. Each table is written with a
+// single statement for the whole batch.
+func insertMovies(ctx context.Context, tx *sql.Tx, movies []*Movie, userID int64) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	// The IDs are allocated up front, so that the movies don't have to be matched
+	// with the inserted rows by their order.
+	query := `SELECT nextval(pg_get_serial_sequence('movies', 'id')) FROM generate_series(1, $1)`
+
+	rows, err := tx.QueryContext(ctx, query, len(movies))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+	for rows.Next() {
+		movie := movies[len(ids)]
+		err := rows.Scan(&movie.ID)
+		if err != nil {
+			return err
+		}
+		byID[movie.ID] = movie
+		ids = append(ids, movie.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	values := make([]string, len(movies))
+	args := make([]interface{}, 0, len(movies)*5)
+	for i, movie := range movies {
+		n := len(args)
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, movie.ID, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+	}
+
+	query = `
+		INSERT INTO movies (id, title, year, runtime, genres)
+		VALUES ` + strings.Join(values, ", ") + `
+		RETURNING id, created_at, version`
+
+	rows, err = tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		var version int32
+		err := rows.Scan(&id, &createdAt, &version)
+		if err != nil {
+			return err
+		}
+		byID[id].CreatedAt = createdAt
+		byID[id].Version = version
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = insertTitles(ctx, tx, movies)
+	if err != nil {
+		return err
+	}
+
+	err = insertExternalIDs(ctx, tx, movies)
+	if err != nil {
+		return err
+	}
+
+	// The first revisions record the movies as they were just inserted.
+	query = `
+		INSERT INTO movie_revisions (movie_id, version, user_id, title, year, runtime, genres, titles, external_ids)
+		SELECT id, version, NULLIF($1, 0), title, year, runtime, genres, ` + revisionSnapshotColumns + `
+		FROM movies
+		WHERE id = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(ids))
+	return err
+}
+
+// Commit commits the import transaction.
+func (imp *MovieImport) Commit() error {
+	defer imp.cancel()
+	return imp.tx.Commit()
 }
 
-// Get fetches a specific record from the movies table.
+// Rollback aborts the import transaction, discarding all inserted movies.
+func (imp *MovieImport) Rollback() error {
+	defer imp.cancel()
+	return imp.tx.Rollback()
+}
+
+// Get fetches a specific record from the movies table, along with its localized
+// titles and external identifiers.
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.get(id, false)
+}
+
+// GetTrashed fetches a specific record in the trash, like Get does for the
+// records that aren't.
+func (m MovieModel) GetTrashed(id int64) (*Movie, error) {
+	return m.get(id, true)
+}
+
+func (m MovieModel) get(id int64, trashed bool) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version FROM movies
-		WHERE id = $1`
+		SELECT id, created_at, updated_at, title, year, runtime, genres, version, average_rating, ratings_count, ` + externalIDsColumn + `, deleted_at
+		FROM movies
+		WHERE id = $1 AND (deleted_at IS NOT NULL) = $2`
 
 	var movie Movie
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, trashed).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingsCount,
+		&movie.ExternalIDs,
+		&movie.DeletedAt,
 	)
 	if err != nil {
 		switch {
@@ -126,36 +475,300 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	err = m.loadTitles(ctx, &movie)
+	if err != nil {
+		return nil, err
+	}
+
 	return &movie, nil
 }
 
-// GetAll returns a slice of movies.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	// Construct the SQL query to retrieve all movie records.
-	query := fmt.Sprintf(
-		`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-			FROM movies
-			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-			AND (genres @> $2 OR $2 = '{}') 
-			ORDER BY %s %s, id ASC
-			LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+// Search modes control how the title search text is parsed into a tsquery.
+const (
+	SearchPlain     = "plain"
+	SearchPhrase    = "phrase"
+	SearchPrefix    = "prefix"
+	SearchWebsearch = "websearch"
+)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// SearchModeSafelist holds the permitted values for the MovieQuery SearchMode field.
+var SearchModeSafelist = []string{SearchPlain, SearchPhrase, SearchPrefix, SearchWebsearch}
+
+// SearchConfigSafelist holds the permitted text search configurations. Each of
+// them has a matching index on the movie titles.
+var SearchConfigSafelist = []string{"simple", "english", "swedish", "norwegian", "danish", "finnish"}
+
+// MovieQuery holds the criteria for selecting the movies in a listing. Movies
+// must have all of Genres and at least one of GenresAny; zero-valued range
+// bounds are ignored. If Fuzzy is set and the full-text title search finds no
+// movies, the title is matched by trigram similarity instead. The JSON names of
+// the fields are those of the query string parameters.
+type MovieQuery struct {
+	Title        string   `json:"title,omitempty"`
+	Genres       []string `json:"genres,omitempty"`
+	GenresAny    []string `json:"genres_any,omitempty"`
+	YearMin      int      `json:"year_min,omitempty"`
+	YearMax      int      `json:"year_max,omitempty"`
+	RuntimeMin   int      `json:"runtime_min,omitempty"`
+	RuntimeMax   int      `json:"runtime_max,omitempty"`
+	Person       int64    `json:"person,omitempty"`
+	SearchMode   string   `json:"search_mode,omitempty"`
+	SearchConfig string   `json:"search_config,omitempty"`
+	Fuzzy        bool     `json:"fuzzy,omitempty"`
+	// trigram is set when falling back to trigram similarity matching.
+	trigram bool
+}
+
+func ValidateMovieQuery(v *validator.Validator, q MovieQuery) {
+	v.Check(len(q.GenresAny) <= 20, "genres_any", "must not contain more than 20 genres")
+	v.Check(validator.Unique(q.GenresAny), "genres_any", "must not contain duplicate values")
+
+	v.Check(q.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(q.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(q.YearMax == 0 || q.YearMin <= q.YearMax, "year_max", "must not be less than year_min")
+
+	v.Check(q.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(q.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(q.RuntimeMax == 0 || q.RuntimeMin <= q.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+
+	v.Check(q.Person >= 0, "person", "must not be negative")
+
+	v.Check(validator.In(q.SearchMode, SearchModeSafelist...), "search_mode", "invalid search_mode value")
+	v.Check(validator.In(q.SearchConfig, SearchConfigSafelist...), "search_config", "invalid search_config value")
+}
+
+// searchConfig returns the text search configuration of the query, which
+// defaults to "simple". It panics if the configuration is not in the safelist,
+// as it's interpolated into SQL.
+func (q MovieQuery) searchConfig() string {
+	if q.SearchConfig == "" {
+		return "simple"
+	}
+	if !validator.In(q.SearchConfig, SearchConfigSafelist...) {
+		panic("unsafe search config: " + q.SearchConfig)
+	}
+	return q.SearchConfig
+}
+
+// textSearch returns the SQL expressions for the title tsvector and for the
+// tsquery of the search text, which is always the $1 argument.
+func (q MovieQuery) textSearch() (string, string) {
+	config := q.searchConfig()
+
+	fn := "plainto_tsquery"
+	switch q.SearchMode {
+	case SearchPhrase:
+		fn = "phraseto_tsquery"
+	case SearchPrefix:
+		fn = "to_tsquery"
+	case SearchWebsearch:
+		fn = "websearch_to_tsquery"
+	}
+
+	return fmt.Sprintf("to_tsvector('%s', title)", config), fmt.Sprintf("%s('%s', $1)", fn, config)
+}
+
+// searchText returns the title search text in the form expected by the tsquery
+// function. In prefix mode, every word of the title is matched as a prefix.
+func (q MovieQuery) searchText() string {
+	if q.SearchMode != SearchPrefix || q.trigram {
+		return q.Title
+	}
+
+	words := strings.FieldsFunc(q.Title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := range words {
+		words[i] += ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// condition returns the SQL condition and arguments matching the movies selected
+// by the query. The title is matched against both the title and the localized
+// titles of the movies. Trashed movies are never matched.
+func (q MovieQuery) condition() (string, []interface{}) {
+	vector, tsquery := q.textSearch()
+
+	match := vector + " @@ " + tsquery
+	if q.trigram {
+		match = "title % $1"
+	}
+	// The title in the subquery refers to the localized title.
+	match += " OR id IN (SELECT movie_id FROM movie_titles WHERE " + match + ")"
+
+	where := `(` + match + ` OR $1 = '')
+			AND (genres @> $2 OR $2 = '{}')
+			AND deleted_at IS NULL`
+
+	genres := q.Genres
+	if genres == nil {
+		genres = []string{}
+	}
 
 	args := []interface{}{
-		title,
+		q.searchText(),
 		pq.Array(genres),
-		filters.limit(),
-		filters.offset(),
 	}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	// The optional conditions are only added when set, so that the planner can
+	// use the indexes on the filtered columns.
+	optional := []struct {
+		set       bool
+		condition string
+		arg       interface{}
+	}{
+		{len(q.GenresAny) > 0, "genres && $%d", pq.Array(q.GenresAny)},
+		{q.YearMin > 0, "year >= $%d", q.YearMin},
+		{q.YearMax > 0, "year <= $%d", q.YearMax},
+		{q.RuntimeMin > 0, "runtime >= $%d", q.RuntimeMin},
+		{q.RuntimeMax > 0, "runtime <= $%d", q.RuntimeMax},
+		{q.Person > 0, "id IN (SELECT movie_id FROM credits WHERE person_id = $%d)", q.Person},
+	}
+
+	for _, o := range optional {
+		if o.set {
+			args = append(args, o.arg)
+			where += fmt.Sprintf("\n\t\t\tAND "+o.condition, len(args))
+		}
+	}
+
+	return where, args
+}
+
+// rankExpressions returns the SQL expressions for the search rank and for the
+// highlighted title of a movie. Both are only computed when searching by title.
+// The rank is that of the best matching of the title and the localized titles,
+// while only the title is highlighted.
+func (q MovieQuery) rankExpressions() (string, string) {
+	// The best rank of any title, where the title in the subquery refers to the
+	// localized title.
+	best := func(rank string) string {
+		return fmt.Sprintf("GREATEST(%s, (SELECT max(%s) FROM movie_titles WHERE movie_id = movies.id))", rank, rank)
+	}
+
+	if q.trigram {
+		return best("similarity(title, $1)"), "''"
+	}
+
+	vector, tsquery := q.textSearch()
+	rank := fmt.Sprintf("CASE WHEN $1 = '' THEN 0::real ELSE %s END", best(fmt.Sprintf("ts_rank(%s, %s)", vector, tsquery)))
+	headline := fmt.Sprintf("CASE WHEN $1 = '' THEN '' ELSE ts_headline('%s', title, %s) END", q.searchConfig(), tsquery)
+	return rank, headline
+}
+
+// GetAll returns a slice of movies. For a fuzzy query, the title is matched by
+// trigram similarity if the full-text search finds no movies at all, rather than
+// none on the requested page.
+func (m MovieModel) GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
+	movies, metadata, err := m.getAll(q, filters)
+	if err != nil || len(movies) > 0 {
+		return movies, metadata, err
+	}
+
+	fallback, err := m.fuzzyFallback(q)
+	if err != nil || !fallback {
+		return movies, metadata, err
+	}
+
+	q.trigram = true
+	movies, metadata, err = m.getAll(q, filters)
+	metadata.FuzzyMatch = true
+	return movies, metadata, err
+}
+
+// fuzzyFallback reports whether the title of a fuzzy query has to be matched by
+// trigram similarity, which is when the full-text search matches no movies.
+func (m MovieModel) fuzzyFallback(q MovieQuery) (bool, error) {
+	if !q.Fuzzy || q.Title == "" {
+		return false, nil
+	}
+
+	where, args := q.condition()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE `+where+`)`, args...).Scan(&exists)
+	return !exists, err
+}
+
+// getAll returns a slice of movies, matching the title as specified by the query.
+func (m MovieModel) getAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
+	// The conditions shared by the listing query and any separate count query.
+	where, args := q.condition()
+	filterArgs := len(args)
+
+	rank, headline := q.rankExpressions()
+
+	// Sorting by rating orders by the average rating, and sorting by relevance
+	// orders by the search rank.
+	sortExpressions := map[string]string{"rating": "average_rating", "relevance": rank}
+
+	// Columns outside a sparse fieldset are replaced by constant placeholders, so
+	// the rows can be scanned the same way. Sort columns are always selected, as
+	// they are needed for the next page cursor.
+	columns := []string{"title", "year", "runtime", "genres", "version", "average_rating", "ratings_count", "external_ids"}
+	expressions := map[string]string{"external_ids": externalIDsColumn}
+	placeholders := map[string]string{
+		"title":          "''",
+		"year":           "0",
+		"runtime":        "0",
+		"genres":         "'{}'::text[]",
+		"version":        "0",
+		"average_rating": "0::real",
+		"ratings_count":  "0",
+		"external_ids":   "NULL",
+	}
+	for i, column := range columns {
+		if !filters.selects(column, sortExpressions) {
+			columns[i] = placeholders[column]
+		} else if expression, ok := expressions[column]; ok {
+			columns[i] = expression
+		}
+	}
+	if !filters.selects("highlight", sortExpressions) {
+		headline = "''"
+	}
+
+	// The window count is only used for an exact total with page-based pagination;
+	// in cursor mode it would only count the rows after the cursor.
+	countColumn := "0"
+	if filters.Total == TotalExact && filters.Cursor == "" {
+		countColumn = "count(*) OVER()"
+	}
+
+	// Restrict the result to the rows after the cursor position, if any.
+	keyset := "TRUE"
+	if filters.Cursor != "" {
+		c, err := decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		keyset, args = filters.keysetCondition(c, sortExpressions, args)
+	}
+
+	// Construct the SQL query to retrieve all movie records. One row more than the
+	// page size is fetched to find out if there is a next page.
+	query := fmt.Sprintf(
+		`SELECT %s, id, created_at, %s, %s, %s
+			FROM movies
+			WHERE %s
+			AND %s
+			ORDER BY %s, id ASC
+			LIMIT $%d OFFSET $%d`,
+		countColumn, strings.Join(columns, ", "), rank, headline, where, keyset, filters.orderBy(sortExpressions), len(args)+1, len(args)+2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, append(args, filters.limit()+1, filters.offset())...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	// Ensure that the resultset is closed before GetAll() returns.
+	// Ensure that the resultset is closed before getAll() returns.
 	defer rows.Close()
 
 	totalRecords := 0
@@ -172,6 +785,11 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+			&movie.ExternalIDs,
+			&movie.rank,
+			&movie.Highlight,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -188,44 +806,338 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// If the extra row was returned there is a next page, which starts after the
+	// last movie on this page.
+	nextCursor := ""
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		last := movies[len(movies)-1]
+		c := cursor{Sort: filters.Sort, ID: last.ID}
+		for _, key := range filters.sortKeys() {
+			c.Values = append(c.Values, last.sortValue(key.column))
+		}
+		nextCursor = c.encode()
+	}
+
+	// Count the matching records separately when the window count wasn't used.
+	countArgs := args[:filterArgs]
+	switch {
+	case filters.Total == TotalExact && filters.Cursor != "":
+		totalRecords, err = m.count(ctx, where, countArgs)
+	case filters.Total == TotalEstimate:
+		totalRecords, err = m.estimate(ctx, where, countArgs)
+	}
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// The localized titles are needed to show them, and to localize the title.
+	if filters.selects("title", sortExpressions) || filters.selects("titles", sortExpressions) {
+		err = m.loadTitles(ctx, movies...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, nextCursor)
 
 	// If everything went OK, then return the slice of movies.
 	return movies, metadata, nil
 }
 
-// Update updates a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
-	query := `
-		UPDATE movies SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1 
-		WHERE id = $5 AND version = $6
-		RETURNING version`
+// exportPageSize is the number of movies that Export reads before loading their
+// localized titles.
+const exportPageSize = 500
 
-	args := []interface{}{
-		movie.Title,
-		movie.Year,
-		movie.Runtime,
-		pq.Array(movie.Genres),
-		movie.ID,
-		movie.Version,
-	}
+// Export calls fn for each movie selected by the query, in id order, along with
+// its localized titles and external identifiers. Movies are read from the
+// database a page at a time, so fn can write them out without the whole result
+// being held in memory. Iteration stops at the first error returned by fn.
+func (m MovieModel) Export(q MovieQuery, fn func(*Movie) error) error {
+	where, args := q.condition()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version, ` + externalIDsColumn + `
+		FROM movies
+		WHERE ` + where + `
+		ORDER BY id ASC`
+
+	// Exports may take much longer than regular queries.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+		return err
+	}
+	defer rows.Close()
+
+	// The titles of each page are loaded together, and the page is passed to fn
+	// before the next one is read.
+	page := make([]*Movie, 0, exportPageSize)
+	flush := func() error {
+		err := m.loadTitles(ctx, page...)
+		if err != nil {
+			return err
+		}
+		for _, movie := range page {
+			err = fn(movie)
+			if err != nil {
+				return err
+			}
+		}
+		page = page[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.ExternalIDs,
+		)
+		if err != nil {
+			return err
+		}
+
+		page = append(page, &movie)
+		if len(page) == exportPageSize {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// TitleSuggestion holds a movie title suggested for a partial title.
+type TitleSuggestion struct {
+	ID         int64   `json:"id"`
+	Title      string  `json:"title"`
+	Similarity float32 `json:"similarity"`
+}
+
+// Autocomplete returns up to limit movie titles that start with the text, or are
+// similar to it by trigram similarity. Titles with a matching prefix come first,
+// followed by the most similar ones. Localized titles are suggested along with
+// the titles, so a movie may be suggested once per title.
+func (m MovieModel) Autocomplete(text string, limit int) ([]*TitleSuggestion, error) {
+	query := `
+		SELECT id, title, similarity(title, $1)
+		FROM (
+			SELECT id, title
+			FROM movies
+			WHERE deleted_at IS NULL
+			UNION
+			SELECT movies.id, movie_titles.title
+			FROM movie_titles
+				INNER JOIN movies ON movies.id = movie_titles.movie_id
+			WHERE movies.deleted_at IS NULL
+		) AS titles
+		WHERE (title ILIKE $2 OR title % $1)
+		ORDER BY title ILIKE $2 DESC, similarity(title, $1) DESC, title ASC, id ASC
+		LIMIT $3`
+
+	// Escape the LIKE wildcards in the text, so that it's matched literally.
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text) + "%"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, text, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*TitleSuggestion{}
+	for rows.Next() {
+		var suggestion TitleSuggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Similarity)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// DuplicateSimilarity is the minimum trigram similarity between the titles of
+// two movies from the same year for them to be considered duplicates.
+const DuplicateSimilarity = 0.6
+
+// DuplicateCandidate holds a movie which may be a duplicate of another.
+type DuplicateCandidate struct {
+	ID         int64   `json:"id"`
+	Title      string  `json:"title"`
+	Year       int32   `json:"year"`
+	Similarity float32 `json:"similarity"`
+}
+
+// FindDuplicates returns the movies from the same year as the movie whose title
+// is equal to its title when normalized, ignoring case, spacing and punctuation,
+// or is similar to it by trigram similarity. The most similar movies come first.
+// Trashed movies, and the movie itself if it's already stored, are ignored.
+func (m MovieModel) FindDuplicates(movie *Movie) ([]*DuplicateCandidate, error) {
+	query := `
+		SELECT id, title, year, similarity(title, $1)
+		FROM movies
+		WHERE year = $2 AND id <> $3 AND deleted_at IS NULL
+		AND (lower(regexp_replace(title, '[^[:alnum:]]+', '', 'g')) = lower(regexp_replace($1, '[^[:alnum:]]+', '', 'g'))
+			OR similarity(title, $1) >= $4)
+		ORDER BY similarity(title, $1) DESC, id ASC
+		LIMIT 10`
+
+	args := []interface{}{movie.Title, movie.Year, movie.ID, DuplicateSimilarity}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*DuplicateCandidate{}
+	for rows.Next() {
+		var candidate DuplicateCandidate
+		err := rows.Scan(&candidate.ID, &candidate.Title, &candidate.Year, &candidate.Similarity)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, &candidate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// count returns the exact number of movies matching the where condition.
+func (m MovieModel) count(ctx context.Context, where string, args []interface{}) (int, error) {
+	query := `SELECT count(*) FROM movies WHERE ` + where
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&total)
+	return total, err
+}
+
+// estimate returns the query planner's estimate of the number of movies matching
+// the where condition, which avoids scanning all matching rows.
+func (m MovieModel) estimate(ctx context.Context, where string, args []interface{}) (int, error) {
+	query := `EXPLAIN (FORMAT JSON) SELECT id FROM movies WHERE ` + where
+
+	var js []byte
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&js)
+	if err != nil {
+		return 0, err
+	}
+
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	err = json.Unmarshal(js, &plans)
+	if err != nil {
+		return 0, err
+	}
+	if len(plans) == 0 {
+		return 0, errors.New("empty query plan")
+	}
+
+	return int(plans[0].Plan.Rows), nil
+}
+
+// Update updates a specific record in the movies table, and records the new state
+// as a revision of the movie made by the given user. The localized titles and
+// external identifiers of the movie are replaced, unless they're nil.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEvent(ctx, tx, EventMovieUpdated, moviePayload(movie))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateMovie updates a specific record in the movies table together with its
+// new revision, as part of a transaction.
+func updateMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	query := `
+		UPDATE movies SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1 
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
+		RETURNING version`
+
+	args := []interface{}{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.ID,
+		movie.Version,
+	}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
 	}
-	return nil
+
+	err = saveTitles(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	err = saveExternalIDs(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	return insertRevision(ctx, tx, movie, userID)
 }
 
-// Delete removes a specific record from the movies table.
+// Delete permanently removes a specific record from the movies table. The
+// movie is removed from any lists, along with its ratings and revisions, by the
+// foreign key constraints of those tables.
 func (m MovieModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
@@ -254,3 +1166,376 @@ func (m MovieModel) Delete(id int64) error {
 
 	return nil
 }
+
+// Merge merges the source movie into the movie, and records the new state of the
+// movie as a revision made by the given user. The movie is updated with its
+// current fields, which are expected to combine those of both movies. The
+// ratings, reviews, credits and list entries of the source movie are moved to
+// the movie, except where the movie already has its own, and the source movie
+// is then permanently removed. All of this happens in a single transaction,
+// which fails with ErrEditConflict if the movie is no longer at its version, or
+// if the source movie has been removed or trashed.
+func (m MovieModel) Merge(movie *Movie, source *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the source movie, so that nothing is added to it while its rows are
+	// being moved.
+	query := `
+		SELECT id FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, source.ID).Scan(&source.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = moveMovieRelations(ctx, tx, source.ID, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	// Whatever is left of the source movie, such as its revisions, images and
+	// the duplicates of the rows of the movie, is removed along with it.
+	query = `DELETE FROM movies WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, source.ID)
+	if err != nil {
+		return err
+	}
+
+	err = updateRatingAggregates(ctx, tx, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE movies SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
+		RETURNING version, average_rating, ratings_count`
+
+	args := []interface{}{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.ID,
+		movie.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.AverageRating, &movie.RatingsCount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = saveTitles(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	// The external identifiers of the source movie were removed with it, so they
+	// can be added to the movie.
+	err = saveExternalIDs(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEvent(ctx, tx, EventMovieUpdated, moviePayload(movie))
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEvent(ctx, tx, EventMovieDeleted, moviePayload(source))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveMovieRelations moves the ratings, reviews, credits and list entries of the
+// source movie to the target movie, as part of a transaction. Where the target
+// movie already has a row of its own, such as a rating by the same user or an
+// entry in the same list, the row of the source movie is left to be removed
+// with it. An entry in a list with both movies takes the earlier of their two
+// positions, and the note of the source entry if it has none of its own.
+func moveMovieRelations(ctx context.Context, tx *sql.Tx, sourceID, targetID int64) error {
+	queries := []string{
+		// A review belongs to a rating, so the ratings are copied to the target
+		// movie along with moving their reviews. The ratings of the source
+		// movie are removed with it.
+		`WITH copied AS (
+			INSERT INTO ratings (movie_id, user_id, created_at, updated_at, rating)
+			SELECT $2, user_id, created_at, updated_at, rating
+			FROM ratings
+			WHERE movie_id = $1
+			ON CONFLICT (movie_id, user_id) DO NOTHING
+			RETURNING user_id
+		)
+		UPDATE reviews SET movie_id = $2
+		FROM copied
+		WHERE reviews.movie_id = $1 AND reviews.user_id = copied.user_id`,
+		`UPDATE credits SET movie_id = $2
+		WHERE movie_id = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM credits AS own
+				WHERE own.movie_id = $2 AND own.person_id = credits.person_id
+					AND own.role = credits.role AND own.character = credits.character
+			)`,
+		`UPDATE list_entries SET position = least(list_entries.position, source.position),
+			note = CASE WHEN list_entries.note = '' THEN source.note ELSE list_entries.note END
+		FROM list_entries AS source
+		WHERE list_entries.movie_id = $2 AND source.movie_id = $1 AND source.list_id = list_entries.list_id`,
+		`UPDATE list_entries SET movie_id = $2
+		WHERE movie_id = $1
+			AND NOT EXISTS (SELECT 1 FROM list_entries AS own WHERE own.movie_id = $2 AND own.list_id = list_entries.list_id)`,
+	}
+
+	for _, query := range queries {
+		_, err := tx.ExecContext(ctx, query, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Trash moves a specific record in the movies table to the trash, by setting its
+// deleted_at timestamp. Trashed records are ignored by Get, GetAll and Update.
+// The record is only trashed if it's still at the version of the movie,
+// otherwise ErrEditConflict is returned. A movie that has already been moved to
+// the trash, or removed, isn't found. Like any other change to the movie, moving
+// it to the trash increments its version, and is recorded as a revision made by
+// the user.
+func (m MovieModel) Trash(movie *Movie, userID int64) error {
+	if movie.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		RETURNING deleted_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, movie.ID, movie.Version).Scan(&movie.DeletedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return trashConflict(ctx, tx, movie.ID)
+		default:
+			return err
+		}
+	}
+
+	err = insertRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEvent(ctx, tx, EventMovieDeleted, moviePayload(movie))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// trashConflict returns the error for a movie that couldn't be moved to the
+// trash: ErrRecordNotFound if it's no longer there to be trashed, or else
+// ErrEditConflict, as it's at another version.
+func trashConflict(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, query, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return ErrEditConflict
+}
+
+// Restore moves a specific record in the movies table out of the trash. The
+// record is only restored if it's still at the version of the movie, otherwise
+// ErrEditConflict is returned. A movie that isn't in the trash isn't found. Like
+// any other change to the movie, restoring it increments its version, and is
+// recorded as a revision made by the user.
+func (m MovieModel) Restore(movie *Movie, userID int64) error {
+	if movie.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NOT NULL
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, movie.ID, movie.Version).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return restoreConflict(ctx, tx, movie.ID)
+		default:
+			return err
+		}
+	}
+	movie.DeletedAt = nil
+
+	err = insertRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEvent(ctx, tx, EventMovieCreated, moviePayload(movie))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// restoreConflict returns the error for a movie that couldn't be restored:
+// ErrRecordNotFound if it's no longer in the trash, or else ErrEditConflict, as
+// it's at another version.
+func restoreConflict(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NOT NULL)`
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, query, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return ErrEditConflict
+}
+
+// GetAllTrashed returns a slice of the movies in the trash.
+func (m MovieModel) GetAllTrashed(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(
+		`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at
+			FROM movies
+			WHERE deleted_at IS NOT NULL
+			ORDER BY %s, id ASC
+			LIMIT $1 OFFSET $2`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	var movies []*Movie
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return movies, metadata, nil
+}
+
+// PurgeTrashed permanently removes the movies that have been in the trash for
+// longer than the retention period, and returns the IDs of the removed records.
+func (m MovieModel) PurgeTrashed(retention time.Duration) ([]int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at < $1
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+var ErrDuplicateRating = errors.New("duplicate rating")
+
+// Rating represents a user's rating of a movie, with an optional review.
+type Rating struct {
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Rating    int32     `json:"rating"`
+	Review    string    `json:"review,omitempty"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Rating != 0, "rating", "must be provided")
+	v.Check(rating.Rating >= 1 && rating.Rating <= 10, "rating", "must be between 1 and 10")
+
+	v.Check(len(rating.Review) <= 10_000, "review", "must not be more than 10000 bytes long")
+}
+
+// RatingModel represents a model of the ratings store.
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new rating, and its review if any, and updates the rating
+// aggregates of the movie.
+func (m RatingModel) Insert(rating *Rating) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO ratings (movie_id, user_id, rating)
+		VALUES ($1, $2, $3)
+		RETURNING created_at, updated_at`
+
+	err = tx.QueryRowContext(ctx, query, rating.MovieID, rating.UserID, rating.Rating).Scan(
+		&rating.CreatedAt,
+		&rating.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "ratings_pkey"`:
+			return ErrDuplicateRating
+		default:
+			return err
+		}
+	}
+
+	err = saveReview(ctx, tx, rating)
+	if err != nil {
+		return err
+	}
+
+	err = updateRatingAggregates(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update replaces an existing rating and its review, and updates the rating
+// aggregates of the movie. An empty review removes any existing review.
+func (m RatingModel) Update(rating *Rating) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE ratings
+		SET rating = $3, updated_at = NOW()
+		WHERE movie_id = $1 AND user_id = $2
+		RETURNING created_at, updated_at`
+
+	err = tx.QueryRowContext(ctx, query, rating.MovieID, rating.UserID, rating.Rating).Scan(
+		&rating.CreatedAt,
+		&rating.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = saveReview(ctx, tx, rating)
+	if err != nil {
+		return err
+	}
+
+	err = updateRatingAggregates(ctx, tx, rating.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a user's rating of a movie, together with the review, and
+// updates the rating aggregates of the movie.
+func (m RatingModel) Delete(movieID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovie(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM ratings
+		WHERE movie_id = $1 AND user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateRatingAggregates(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockMovie locks the movie row for the rest of the transaction, so that
+// concurrent rating changes update the aggregates one at a time. Movies in the
+// trash are treated as not found.
+func lockMovie(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `
+		SELECT id FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, movieID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveReview inserts or replaces the review of a rating, or removes it if the
+// review is empty, as part of a transaction.
+func saveReview(ctx context.Context, tx *sql.Tx, rating *Rating) error {
+	if rating.Review == "" {
+		query := `
+			DELETE FROM reviews
+			WHERE movie_id = $1 AND user_id = $2`
+
+		_, err := tx.ExecContext(ctx, query, rating.MovieID, rating.UserID)
+		return err
+	}
+
+	query := `
+		INSERT INTO reviews (movie_id, user_id, body)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, user_id) DO UPDATE
+		SET body = EXCLUDED.body, updated_at = NOW()`
+
+	_, err := tx.ExecContext(ctx, query, rating.MovieID, rating.UserID, rating.Review)
+	return err
+}
+
+// updateRatingAggregates recalculates the average rating and number of ratings
+// of a movie, as part of a transaction. Ratings aren't edits of the movie, so
+// its version is left unchanged, and the entity tag of the movie is derived from
+// the aggregates as well.
+func updateRatingAggregates(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `
+		UPDATE movies
+		SET (average_rating, ratings_count) = (
+			SELECT coalesce(avg(rating), 0), count(*)
+			FROM ratings
+			WHERE movie_id = $1
+		)
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
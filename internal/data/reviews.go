@@ -0,0 +1,105 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Review represents a user's written review of a movie, along with the rating
+// it accompanies.
+type Review struct {
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Rating    int32     `json:"rating"`
+	Body      string    `json:"body"`
+}
+
+// ReviewModel represents a model of the reviews store.
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// GetAllForMovie returns a slice of the reviews of a specific movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	sortExpressions := map[string]string{
+		"created_at": "reviews.created_at",
+		"rating":     "ratings.rating",
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), reviews.user_id, users.name, reviews.created_at, reviews.updated_at,
+			ratings.rating, reviews.body
+		FROM reviews
+			INNER JOIN ratings USING (movie_id, user_id)
+			INNER JOIN users ON users.id = reviews.user_id
+		WHERE reviews.movie_id = $1
+		ORDER BY %s, reviews.user_id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy(sortExpressions))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&totalRecords,
+			&review.UserID,
+			&review.UserName,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Rating,
+			&review.Body,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return reviews, metadata, nil
+}
+
+// Delete removes the review of a movie written by a specific user, leaving the
+// rating in place.
+func (m ReviewModel) Delete(movieID, userID int64) error {
+	query := `
+		DELETE FROM reviews
+		WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision holds the state of a movie at a specific version, together with
+// the user that made the change. Every change to the movie is recorded, including
+// moving it to and from the trash, which leaves the recorded state unchanged.
+// The titles and external identifiers are nil in the revisions made before they
+// were recorded.
+type MovieRevision struct {
+	MovieID     int64        `json:"movie_id"`
+	Version     int32        `json:"version"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UserID      *int64       `json:"user_id,omitempty"`
+	Title       string       `json:"title"`
+	Titles      []MovieTitle `json:"titles"`
+	ExternalIDs ExternalIDs  `json:"external_ids"`
+	Year        int32        `json:"year"`
+	Runtime     Runtime      `json:"runtime"`
+	Genres      []string     `json:"genres"`
+}
+
+// formattedRevision is a revision that's encoded to JSON with its runtime in a
+// specific format.
+type formattedRevision struct {
+	*MovieRevision
+	Runtime FormattedRuntime `json:"runtime"`
+}
+
+// WithRuntimeFormat returns the revision for encoding to JSON with its runtime
+// in the format.
+func (rev *MovieRevision) WithRuntimeFormat(format RuntimeFormat) interface{} {
+	if format == RuntimeFormatDefault {
+		return rev
+	}
+	return &formattedRevision{MovieRevision: rev, Runtime: rev.Runtime.Formatted(format)}
+}
+
+// FieldChange holds the previous and the new value of a changed movie field.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// DiffRevisions returns the fields that differ between two revisions of a movie,
+// keyed by their JSON field name, with runtimes in the given format. A nil from
+// revision is treated as the state before the movie was created, so every field
+// is reported as changed. The titles and external identifiers are only compared
+// if both revisions recorded them.
+func DiffRevisions(from, to *MovieRevision, format RuntimeFormat) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if from == nil {
+		from = &MovieRevision{Titles: []MovieTitle{}, ExternalIDs: ExternalIDs{}}
+	}
+
+	type field struct {
+		name     string
+		from, to interface{}
+	}
+
+	fields := []field{
+		{"title", from.Title, to.Title},
+		{"year", from.Year, to.Year},
+		{"runtime", from.Runtime.Formatted(format), to.Runtime.Formatted(format)},
+		{"genres", from.Genres, to.Genres},
+	}
+	if from.Titles != nil && to.Titles != nil {
+		fields = append(fields, field{"titles", from.Titles, to.Titles})
+	}
+	if from.ExternalIDs != nil && to.ExternalIDs != nil {
+		fields = append(fields, field{"external_ids", from.ExternalIDs, to.ExternalIDs})
+	}
+
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.from, f.to) {
+			changes[f.name] = FieldChange{From: f.from, To: f.to}
+		}
+	}
+
+	return changes
+}
+
+// Apply copies the movie fields recorded in the revision onto the movie. It
+// returns the JSON names of the fields that the revision didn't record, which
+// are left as they are.
+func (rev *MovieRevision) Apply(movie *Movie) []string {
+	movie.Title = rev.Title
+	movie.Year = rev.Year
+	movie.Runtime = rev.Runtime
+	movie.Genres = rev.Genres
+
+	var unrecorded []string
+	if rev.Titles != nil {
+		movie.Titles = rev.Titles
+	} else {
+		unrecorded = append(unrecorded, "titles")
+	}
+	if rev.ExternalIDs != nil {
+		movie.ExternalIDs = rev.ExternalIDs
+	} else {
+		unrecorded = append(unrecorded, "external_ids")
+	}
+
+	return unrecorded
+}
+
+// revisionSnapshotColumns selects the localized titles and the external
+// identifiers of the movie in a query on the movies table, as they're recorded
+// in the titles and external_ids columns of a revision.
+const revisionSnapshotColumns = titlesColumn + `, coalesce(` + externalIDsColumn + `, '{}')`
+
+// insertRevision records the current state of the movie as a new revision,
+// as part of the transaction that changed it. The state is read back from the
+// movies table, after the titles and external identifiers have been saved.
+func insertRevision(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, user_id, title, year, runtime, genres, titles, external_ids)
+		SELECT id, version, NULLIF($2, 0), title, year, runtime, genres, ` + revisionSnapshotColumns + `
+		FROM movies
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movie.ID, userID)
+	return err
+}
+
+// scanSnapshot returns a scanner for the JSON of a revision's titles or external
+// identifiers, which leaves the destination nil if they weren't recorded.
+func scanSnapshot(dest interface{}) sql.Scanner {
+	return snapshotScanner{dest}
+}
+
+type snapshotScanner struct {
+	dest interface{}
+}
+
+func (s snapshotScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, s.dest)
+	case string:
+		return json.Unmarshal([]byte(src), s.dest)
+	}
+	return fmt.Errorf("cannot scan %T into a revision snapshot", src)
+}
+
+// RevisionModel wraps a sql.DB connection pool.
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// Get fetches a specific revision of a movie.
+func (m RevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id, version, created_at, user_id, title, titles, external_ids, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	var rev MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&rev.MovieID,
+		&rev.Version,
+		&rev.CreatedAt,
+		&rev.UserID,
+		&rev.Title,
+		scanSnapshot(&rev.Titles),
+		scanSnapshot(&rev.ExternalIDs),
+		&rev.Year,
+		&rev.Runtime,
+		pq.Array(&rev.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rev, nil
+}
+
+// GetPrevious fetches the latest revision of a movie before the given version.
+// It returns ErrRecordNotFound if there is no earlier revision.
+func (m RevisionModel) GetPrevious(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+		SELECT version FROM movie_revisions
+		WHERE movie_id = $1 AND version < $2
+		ORDER BY version DESC
+		LIMIT 1`
+
+	var previous int32
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return m.Get(movieID, previous)
+}
+
+// GetAllForMovie returns a slice of the revisions of a specific movie.
+func (m RevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_id, version, created_at, user_id, title, titles, external_ids, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	var revisions []*MovieRevision
+
+	for rows.Next() {
+		var rev MovieRevision
+		err := rows.Scan(
+			&totalRecords,
+			&rev.MovieID,
+			&rev.Version,
+			&rev.CreatedAt,
+			&rev.UserID,
+			&rev.Title,
+			scanSnapshot(&rev.Titles),
+			scanSnapshot(&rev.ExternalIDs),
+			&rev.Year,
+			&rev.Runtime,
+			pq.Array(&rev.Genres),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return revisions, metadata, nil
+}
@@ -3,44 +3,225 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
-// Runtime represents a movie runtime in minutes.
+// RuntimeFormat identifies the JSON representation of a runtime.
+type RuntimeFormat string
+
+// Runtime formats. The default format is a string such as "112 mins".
+const (
+	RuntimeFormatDefault RuntimeFormat = ""
+	RuntimeFormatMinutes RuntimeFormat = "minutes" // 112
+	RuntimeFormatISO8601 RuntimeFormat = "iso8601" // "PT1H52M"
+	RuntimeFormatHuman   RuntimeFormat = "human"   // "1h 52m"
+)
+
+// RuntimeFormatSafelist holds the runtime formats that clients can ask for.
+var RuntimeFormatSafelist = []string{
+	string(RuntimeFormatMinutes),
+	string(RuntimeFormatISO8601),
+	string(RuntimeFormatHuman),
+}
+
+// Runtime represents a movie runtime in minutes. It's encoded to JSON in the
+// default format, such as "112 mins"; see FormattedRuntime for the others.
 type Runtime int32
 
 func (r Runtime) MarshalJSON() ([]byte, error) {
-	jsonValue := fmt.Sprintf("%d mins", r)
+	return r.Formatted(RuntimeFormatDefault).MarshalJSON()
+}
+
+// Formatted returns the runtime for encoding to JSON in the format.
+func (r Runtime) Formatted(format RuntimeFormat) FormattedRuntime {
+	return FormattedRuntime{Runtime: r, Format: format}
+}
+
+// FormattedRuntime is a runtime that's encoded to JSON in a specific format.
+type FormattedRuntime struct {
+	Runtime Runtime
+	Format  RuntimeFormat
+}
+
+func (r FormattedRuntime) MarshalJSON() ([]byte, error) {
+	hours, minutes := r.Runtime/60, r.Runtime%60
+
+	var jsonValue string
+	switch r.Format {
+	case RuntimeFormatMinutes:
+		return []byte(strconv.Itoa(int(r.Runtime))), nil
+	case RuntimeFormatISO8601:
+		jsonValue = "PT"
+		if hours > 0 {
+			jsonValue += fmt.Sprintf("%dH", hours)
+		}
+		if minutes > 0 || hours == 0 {
+			jsonValue += fmt.Sprintf("%dM", minutes)
+		}
+	case RuntimeFormatHuman:
+		switch {
+		case hours == 0:
+			jsonValue = fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			jsonValue = fmt.Sprintf("%dh", hours)
+		default:
+			jsonValue = fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+	default:
+		jsonValue = fmt.Sprintf("%d mins", r.Runtime)
+	}
+
 	quotedJSONValue := strconv.Quote(jsonValue)
 	return []byte(quotedJSONValue), nil
 }
 
+// UnmarshalJSON accepts a runtime as a JSON integer number of minutes, or as a
+// string in any of the formats accepted by ParseRuntime.
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	if len(jsonValue) > 0 && jsonValue[0] != '"' {
+		i, err := strconv.ParseInt(string(jsonValue), 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		*r = Runtime(i)
+		return nil
+	}
+
 	// Remove the surrounding double-quotes from this string.
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
 
-	// Split the string to isolate the part containing the number.
-	parts := strings.Split(unquotedJSONValue, " ")
+	runtime, err := ParseRuntime(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
+	*r = runtime
+
+	return nil
+}
+
+// ParseRuntime parses a runtime given as a number of minutes ("112"), as hours
+// and minutes with units ("112 mins", "1h 52m", "1 hour 52 minutes"), or as an
+// ISO 8601 duration ("PT112M", "PT1H52M"). Case and spacing are ignored. An ISO
+// 8601 duration may include seconds, as long as it's a whole number of minutes.
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
 
-	// Sanity check the parts of the string to make sure it was in the expected format.
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+	var minutes int64
+	var ok bool
+	if strings.HasPrefix(s, "pt") {
+		minutes, ok = parseISO8601Runtime(s[2:])
+	} else {
+		minutes, ok = parseHumanRuntime(s)
+	}
+	if !ok || minutes > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
 	}
 
-	// Otherwise, parse the string containing the number into an int32.
-	i, err := strconv.ParseInt(parts[0], 10, 32)
-	if err != nil {
-		return ErrInvalidRuntimeFormat
+	return Runtime(minutes), nil
+}
+
+// runtimeUnits holds the number of seconds in each of the units accepted in
+// human-friendly runtimes.
+var runtimeUnits = map[string]int64{
+	"h":       3600,
+	"hr":      3600,
+	"hrs":     3600,
+	"hour":    3600,
+	"hours":   3600,
+	"m":       60,
+	"min":     60,
+	"mins":    60,
+	"minute":  60,
+	"minutes": 60,
+}
+
+// parseHumanRuntime parses a bare number of minutes, or numbers of hours and
+// minutes followed by their units, with hours first.
+func parseHumanRuntime(s string) (int64, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, n >= 0
 	}
 
-	// Convert the int32 to a Runtime type and assign this to the receiver.
-	*r = Runtime(i)
+	var seconds int64
+	last := int64(math.MaxInt64)
+	for s != "" {
+		n, rest, ok := cutNumber(s)
+		if !ok {
+			return 0, false
+		}
+		rest = strings.TrimLeft(rest, " ")
 
-	return nil
+		i := strings.IndexFunc(rest, func(r rune) bool { return r < 'a' || r > 'z' })
+		if i < 0 {
+			i = len(rest)
+		}
+		unit, ok := runtimeUnits[rest[:i]]
+		if !ok || unit >= last {
+			return 0, false
+		}
+		last = unit
+
+		seconds += n * unit
+		s = strings.TrimLeft(rest[i:], " ")
+	}
+
+	return seconds / 60, last != math.MaxInt64
+}
+
+// parseISO8601Runtime parses the time part of an ISO 8601 duration, following
+// the "PT" designators, which is a whole number of minutes.
+func parseISO8601Runtime(s string) (int64, bool) {
+	var seconds int64
+	last := int64(math.MaxInt64)
+	for s != "" {
+		n, rest, ok := cutNumber(s)
+		if !ok || rest == "" {
+			return 0, false
+		}
+
+		var unit int64
+		switch rest[0] {
+		case 'h':
+			unit = 3600
+		case 'm':
+			unit = 60
+		case 's':
+			unit = 1
+		}
+		if unit == 0 || unit >= last {
+			return 0, false
+		}
+		last = unit
+
+		seconds += n * unit
+		s = rest[1:]
+	}
+
+	return seconds / 60, last != math.MaxInt64 && seconds%60 == 0
+}
+
+// cutNumber returns the non-negative integer at the start of s, and the rest of
+// s following it.
+func cutNumber(s string) (int64, string, bool) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	// Limit the number of digits, so that the runtime can't overflow.
+	if i == 0 || i > 9 {
+		return 0, "", false
+	}
+
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return n, s[i:], true
 }
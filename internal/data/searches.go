@@ -0,0 +1,435 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// SavedSearch represents a movie query saved by a user. If Notify is set, the
+// user is sent the movies that match the query as they're created. Each email
+// holds a token that unsubscribes from the notifications without signing in.
+type SavedSearch struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	Query     MovieQuery `json:"query"`
+	Notify    bool       `json:"notify"`
+	Version   int32      `json:"version"`
+	// LastSeq is the sequence number of the change in the change feed up to
+	// which created movies have been matched.
+	LastSeq int64 `json:"-"`
+}
+
+// DueSavedSearch is a saved search with new changes to match, together with the
+// name and email address of its owner.
+type DueSavedSearch struct {
+	*SavedSearch
+	UserName  string
+	UserEmail string
+}
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch) {
+	v.Check(search.Name != "", "name", "must be provided")
+	v.Check(len(search.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(search.Query.Title) <= 500, "query", "must not have a title more than 500 bytes long")
+	v.Check(len(search.Query.Genres) <= 20, "query", "must not contain more than 20 genres")
+	v.Check(validator.Unique(search.Query.Genres), "query", "must not contain duplicate genres")
+	ValidateMovieQuery(v, search.Query)
+}
+
+// generateUnsubscribeToken returns a random token in the same form as the
+// plaintext of a Token, along with its SHA-256 hash, which is what's stored.
+func generateUnsubscribeToken() (string, []byte, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return plaintext, hash[:], nil
+}
+
+// SavedSearchModel represents a model of the saved searches store.
+type SavedSearchModel struct {
+	DB *sql.DB
+}
+
+// savedSearchColumns are the columns selected for a SavedSearch, in the order
+// scanned by scanSavedSearch.
+const savedSearchColumns = `saved_searches.id, saved_searches.created_at, saved_searches.user_id,
+		saved_searches.name, saved_searches.query, saved_searches.notify, saved_searches.last_seq,
+		saved_searches.version`
+
+// scanSavedSearch returns the arguments for scanning savedSearchColumns into the
+// search. The query is scanned as a JSON document, which has to be decoded into
+// the search after scanning.
+func scanSavedSearch(search *SavedSearch, query *[]byte) []interface{} {
+	return []interface{}{
+		&search.ID,
+		&search.CreatedAt,
+		&search.UserID,
+		&search.Name,
+		query,
+		&search.Notify,
+		&search.LastSeq,
+		&search.Version,
+	}
+}
+
+// Insert inserts a new saved search, which is matched against the movies created
+// from now on.
+func (m SavedSearchModel) Insert(search *SavedSearch) error {
+	query, err := json.Marshal(search.Query)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		INSERT INTO saved_searches (user_id, name, query, notify, last_seq)
+		VALUES ($1, $2, $3, $4, (SELECT COALESCE(max(seq), 0) FROM movie_changes))
+		RETURNING id, created_at, last_seq, version`
+
+	args := []interface{}{search.UserID, search.Name, string(query), search.Notify}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&search.ID, &search.CreatedAt, &search.LastSeq, &search.Version)
+}
+
+// Get fetches a specific saved search.
+func (m SavedSearchModel) Get(id int64) (*SavedSearch, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `SELECT ` + savedSearchColumns + `
+		FROM saved_searches
+		WHERE id = $1`
+
+	return m.getOne(stmt, id)
+}
+
+// GetAllForUser returns a slice of the saved searches of a specific user.
+func (m SavedSearchModel) GetAllForUser(userID int64, filters Filters) ([]*SavedSearch, Metadata, error) {
+	stmt := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM saved_searches
+		WHERE user_id = $1
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, savedSearchColumns, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	searches := []*SavedSearch{}
+
+	for rows.Next() {
+		var search SavedSearch
+		var query []byte
+		err := rows.Scan(append([]interface{}{&totalRecords}, scanSavedSearch(&search, &query)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		err = json.Unmarshal(query, &search.Query)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		searches = append(searches, &search)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return searches, metadata, nil
+}
+
+// Update updates the name, query and notifications of a saved search, checking
+// for edit conflicts with its version. When notifications are turned back on,
+// the search is matched against the movies created from then on, rather than
+// all those created while they were off.
+func (m SavedSearchModel) Update(search *SavedSearch) error {
+	query, err := json.Marshal(search.Query)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		UPDATE saved_searches
+		SET name = $1, query = $2, notify = $3, version = version + 1,
+			last_seq = CASE WHEN $3 AND NOT notify THEN (SELECT COALESCE(max(seq), 0) FROM movie_changes) ELSE last_seq END
+		WHERE id = $4 AND version = $5
+		RETURNING last_seq, version`
+
+	args := []interface{}{search.Name, string(query), search.Notify, search.ID, search.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, args...).Scan(&search.LastSeq, &search.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a saved search.
+func (m SavedSearchModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	stmt := `
+		DELETE FROM saved_searches
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// NewUnsubscribeToken creates a token that unsubscribes from the notifications
+// of a saved search until it expires, and returns its plaintext. The expired
+// tokens of the search are removed at the same time.
+func (m SavedSearchModel) NewUnsubscribeToken(searchID int64, ttl time.Duration) (string, error) {
+	plaintext, hash, err := generateUnsubscribeToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM saved_search_tokens WHERE saved_search_id = $1 AND expiry < NOW()`, searchID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `
+		INSERT INTO saved_search_tokens (hash, saved_search_id, expiry)
+		VALUES ($1, $2, $3)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, hash, searchID, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// GetForUnsubscribeToken fetches the saved search that an unexpired unsubscribe
+// token belongs to.
+func (m SavedSearchModel) GetForUnsubscribeToken(token string) (*SavedSearch, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	stmt := `SELECT ` + savedSearchColumns + `
+		FROM saved_searches
+			INNER JOIN saved_search_tokens ON saved_search_tokens.saved_search_id = saved_searches.id
+		WHERE saved_search_tokens.hash = $1 AND saved_search_tokens.expiry > $2`
+
+	return m.getOne(stmt, hash[:], time.Now())
+}
+
+// Unsubscribe turns off the notifications of the saved search that an unexpired
+// unsubscribe token belongs to, and returns the search.
+func (m SavedSearchModel) Unsubscribe(token string) (*SavedSearch, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	stmt := `
+		UPDATE saved_searches SET notify = false, version = version + 1
+		FROM saved_search_tokens
+		WHERE saved_search_tokens.saved_search_id = saved_searches.id
+			AND saved_search_tokens.hash = $1 AND saved_search_tokens.expiry > $2
+		RETURNING ` + savedSearchColumns
+
+	return m.getOne(stmt, hash[:], time.Now())
+}
+
+// getOne runs a statement that returns a single saved search.
+func (m SavedSearchModel) getOne(stmt string, args ...interface{}) (*SavedSearch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var search SavedSearch
+	var query []byte
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(scanSavedSearch(&search, &query)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(query, &search.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &search, nil
+}
+
+// GetAllDue returns the saved searches with notifications turned on, of activated
+// users, that haven't been matched up to the change with the sequence number.
+func (m SavedSearchModel) GetAllDue(upTo int64) ([]*DueSavedSearch, error) {
+	stmt := `SELECT ` + savedSearchColumns + `, users.name, users.email
+		FROM saved_searches
+			INNER JOIN users ON users.id = saved_searches.user_id
+		WHERE saved_searches.notify AND users.activated AND saved_searches.last_seq < $1
+		ORDER BY saved_searches.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, upTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*DueSavedSearch{}
+
+	for rows.Next() {
+		search := DueSavedSearch{SavedSearch: &SavedSearch{}}
+		var query []byte
+		err := rows.Scan(append(scanSavedSearch(search.SavedSearch, &query), &search.UserName, &search.UserEmail)...)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(query, &search.Query)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, &search)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+// Advance moves a saved search forward to the change with the sequence number,
+// if it hasn't been moved since it was fetched. It reports whether it was moved,
+// so that the changes in between are only matched once, even when more than one
+// matcher is running.
+func (m SavedSearchModel) Advance(search *SavedSearch, upTo int64) (bool, error) {
+	stmt := `
+		UPDATE saved_searches SET last_seq = $1
+		WHERE id = $2 AND last_seq = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, upTo, search.ID, search.LastSeq)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// GetCreatedMatches returns up to limit of the movies that match the query and
+// were created between the changes with the sequence numbers, after the first and
+// up to the second, along with the total number of them. Movies restored from the
+// trash count as created.
+func (m MovieModel) GetCreatedMatches(q MovieQuery, after, upTo int64, limit int) ([]*Movie, int, error) {
+	where, args := q.condition()
+	args = append(args, after, upTo, limit)
+
+	stmt := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE %s
+			AND id IN (SELECT movie_id FROM movie_changes WHERE kind = 'created' AND seq > $%d AND seq <= $%d)
+		ORDER BY id ASC
+		LIMIT $%d`, where, len(args)-2, len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	total := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&total,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return movies, total, nil
+}
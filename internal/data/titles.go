@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// LanguageRX matches lowercase BCP 47 language tags, such as "sv" or "en-gb".
+var LanguageRX = regexp.MustCompile("^[a-z]{2,3}(-[a-z0-9]{2,8})*$")
+
+// MovieTitle holds an alternative title of a movie in a specific language. At
+// most one of the titles of a movie is its original title.
+type MovieTitle struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Original bool   `json:"original,omitempty"`
+}
+
+// NormalizeLanguage returns the language tag in the form it's stored and
+// compared in. Tags are case-insensitive, and underscores are accepted in place
+// of hyphens.
+func NormalizeLanguage(tag string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "_", "-")
+}
+
+// NormalizeTitles normalizes the language tags of the titles in place.
+func NormalizeTitles(titles []MovieTitle) {
+	for i := range titles {
+		titles[i].Language = NormalizeLanguage(titles[i].Language)
+	}
+}
+
+// baseLanguage returns the primary language subtag of a normalized tag, which
+// is the tag without any region or script.
+func baseLanguage(tag string) string {
+	base, _, _ := strings.Cut(tag, "-")
+	return base
+}
+
+// ValidateMovieTitles checks the localized titles of a movie, whose languages
+// must already be normalized.
+func ValidateMovieTitles(v *validator.Validator, titles []MovieTitle) {
+	v.Check(len(titles) <= 50, "titles", "must not contain more than 50 titles")
+
+	languages := make([]string, 0, len(titles))
+	originals := 0
+	for _, title := range titles {
+		v.Check(validator.Matches(title.Language, LanguageRX), "titles", "must have a language tag such as en or sv-SE")
+		v.Check(title.Title != "", "titles", "must have a title")
+		v.Check(len(title.Title) <= 500, "titles", "must not have titles more than 500 bytes long")
+
+		languages = append(languages, title.Language)
+		if title.Original {
+			originals++
+		}
+	}
+
+	v.Check(validator.Unique(languages), "titles", "must not contain more than one title per language")
+	v.Check(originals <= 1, "titles", "must not contain more than one original title")
+}
+
+// Localize sets the title of the movie to its title in the first of the given
+// languages that it has one in, and returns the language of that title. The title
+// is left unchanged if there is none, and an empty string is returned.
+func (movie *Movie) Localize(languages []string) string {
+	title, ok := movie.LocalTitle(languages)
+	if !ok {
+		return ""
+	}
+	movie.Title = title.Title
+	return title.Language
+}
+
+// LocalTitle returns the localized title of the movie in the first of the given
+// languages that it has one in. The languages must be normalized. A title in the
+// same language but for another region, such as "en-us" for "en-gb", is used
+// when there is no exact match.
+func (movie *Movie) LocalTitle(languages []string) (MovieTitle, bool) {
+	for _, language := range languages {
+		for _, title := range movie.Titles {
+			if title.Language == language {
+				return title, true
+			}
+		}
+		for _, title := range movie.Titles {
+			if baseLanguage(title.Language) == baseLanguage(language) {
+				return title, true
+			}
+		}
+	}
+	return MovieTitle{}, false
+}
+
+// HasTitle reports whether the movie has a localized title in the language.
+func (movie *Movie) HasTitle(language string) bool {
+	for _, title := range movie.Titles {
+		if title.Language == language {
+			return true
+		}
+	}
+	return false
+}
+
+// saveTitles replaces the localized titles of the movie, as part of the
+// transaction that inserts or updates it. Nil titles are left unchanged.
+func saveTitles(ctx context.Context, tx *sql.Tx, movie *Movie) error {
+	if movie.Titles == nil {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM movie_titles WHERE movie_id = $1`, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_titles (movie_id, language, title, original)
+		VALUES ($1, $2, $3, $4)`
+
+	for _, title := range movie.Titles {
+		_, err := tx.ExecContext(ctx, query, movie.ID, title.Language, title.Title, title.Original)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertTitles inserts the localized titles of a batch of new movies, as part of
+// a transaction.
+func insertTitles(ctx context.Context, tx *sql.Tx, movies []*Movie) error {
+	var ids []int64
+	var languages, titles []string
+	var originals []bool
+	for _, movie := range movies {
+		for _, title := range movie.Titles {
+			ids = append(ids, movie.ID)
+			languages = append(languages, title.Language)
+			titles = append(titles, title.Title)
+			originals = append(originals, title.Original)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO movie_titles (movie_id, language, title, original)
+		SELECT * FROM unnest($1::bigint[], $2::text[], $3::text[], $4::boolean[])`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(ids), pq.Array(languages), pq.Array(titles), pq.Array(originals))
+	return err
+}
+
+// titlesColumn selects the localized titles of the movie in a query on the
+// movies table, as a JSON array ordered by language.
+const titlesColumn = `(SELECT coalesce(jsonb_agg(jsonb_build_object('language', language, 'title', title, 'original', original) ORDER BY language), '[]') FROM movie_titles WHERE movie_id = movies.id)`
+
+// loadTitles sets the localized titles of the movies, ordered by language.
+func (m MovieModel) loadTitles(ctx context.Context, movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+	for _, movie := range movies {
+		movie.Titles = []MovieTitle{}
+		byID[movie.ID] = movie
+		ids = append(ids, movie.ID)
+	}
+
+	query := `
+		SELECT movie_id, language, title, original
+		FROM movie_titles
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, language`
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int64
+		var title MovieTitle
+		err := rows.Scan(&movieID, &title.Language, &title.Title, &title.Original)
+		if err != nil {
+			return err
+		}
+		byID[movieID].Titles = append(byID[movieID].Titles, title)
+	}
+
+	return rows.Err()
+}
@@ -205,6 +205,42 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// Activate activates a user, checking for edit conflicts, and publishes the
+// activation to the webhooks in the same transaction.
+func (m UserModel) Activate(user *User) error {
+	query := `
+		UPDATE users SET activated = true, version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	user.Activated = true
+
+	err = enqueueEvent(ctx, tx, EventUserActivated, map[string]interface{}{"user": user})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
@@ -0,0 +1,446 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lsjoeberg/greenlight/internal/validator"
+)
+
+// Webhook events. A movie moved to the trash is deleted, and a movie restored
+// from the trash is created again, as in the change feed.
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventUserActivated = "user.activated"
+)
+
+// WebhookEvents holds the events that webhooks can subscribe to.
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted, EventUserActivated}
+
+// Webhook delivery statuses. A pending delivery is retried until it succeeds,
+// or fails for good after the last attempt.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook represents a subscription of an external endpoint to events. Events
+// are sent as signed POST requests to the URL. The secret used for signing is
+// never included in responses.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+// WebhookDelivery represents an event sent, or to be sent, to a webhook, along
+// with the outcome of the latest attempt.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus *int32          `json:"response_status,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	RedeliveryOf   *int64          `json:"redelivery_of,omitempty"`
+}
+
+// PendingDelivery is a delivery claimed for sending, together with the URL and
+// secret of its webhook.
+type PendingDelivery struct {
+	*WebhookDelivery
+	URL    string
+	Secret string
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least 1 event")
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "invalid event value")
+	}
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 256, "secret", "must not be more than 256 bytes long")
+}
+
+// WebhookModel represents a model of the webhooks store.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// Insert inserts a new webhook.
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, events, secret, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{webhook.URL, pq.Array(webhook.Events), webhook.Secret, webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Get fetches a specific webhook.
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, url, events, secret, active, version
+		FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var webhook Webhook
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		pq.Array(&webhook.Events),
+		&webhook.Secret,
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// GetAll returns a slice of all webhooks.
+func (m WebhookModel) GetAll(filters Filters) ([]*Webhook, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, url, events, secret, active, version
+		FROM webhooks
+		ORDER BY %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+		err := rows.Scan(
+			&totalRecords,
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.Secret,
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return webhooks, metadata, nil
+}
+
+// Update updates a webhook, checking for edit conflicts with its version.
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks SET url = $1, events = $2, secret = $3, active = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []interface{}{webhook.URL, pq.Array(webhook.Events), webhook.Secret, webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a webhook together with its deliveries.
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// WebhookDeliveryModel represents a model of the webhook delivery log.
+type WebhookDeliveryModel struct {
+	DB *sql.DB
+}
+
+// deliveryColumns are the columns selected for a WebhookDelivery, in the order
+// scanned by scanDelivery.
+const deliveryColumns = `webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.created_at,
+		webhook_deliveries.event, webhook_deliveries.payload, webhook_deliveries.status,
+		webhook_deliveries.attempts, webhook_deliveries.next_attempt_at, webhook_deliveries.last_attempt_at,
+		webhook_deliveries.response_status, webhook_deliveries.last_error, webhook_deliveries.redelivery_of`
+
+// scanDelivery returns the arguments for scanning deliveryColumns into the delivery.
+func scanDelivery(delivery *WebhookDelivery) []interface{} {
+	return []interface{}{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.CreatedAt,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.LastError,
+		&delivery.RedeliveryOf,
+	}
+}
+
+// enqueueEvent records a pending delivery of an event to each active webhook that
+// is subscribed to it, one for each of the payloads, as part of the transaction
+// that makes the change the event reports. The deliveries are only sent once the
+// transaction has been committed, and are discarded if it's rolled back.
+func enqueueEvent(ctx context.Context, tx *sql.Tx, event string, payloads ...interface{}) error {
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	documents := make([]string, len(payloads))
+	for i, payload := range payloads {
+		js, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		documents[i] = string(js)
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT webhooks.id, $1::text, payloads.payload::jsonb
+		FROM webhooks
+			CROSS JOIN unnest($2::text[]) WITH ORDINALITY AS payloads(payload, position)
+		WHERE webhooks.active AND $1 = ANY(webhooks.events)
+		ORDER BY payloads.position, webhooks.id`
+
+	_, err := tx.ExecContext(ctx, query, event, pq.Array(documents))
+	return err
+}
+
+// Claim returns up to limit pending deliveries of active webhooks that are due,
+// and postpones their next attempt by the lease duration. A delivery that isn't
+// recorded within the lease, such as when the server stops while sending it, is
+// claimed again after the lease has expired.
+func (m WebhookDeliveryModel) Claim(limit int, lease time.Duration) ([]*PendingDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * interval '1 millisecond'
+		FROM webhooks
+		WHERE webhooks.id = webhook_deliveries.webhook_id AND webhook_deliveries.id IN (
+			SELECT webhook_deliveries.id
+			FROM webhook_deliveries
+				INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+			WHERE webhook_deliveries.status = 'pending' AND webhook_deliveries.next_attempt_at <= NOW()
+				AND webhooks.active
+			ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+			LIMIT $1
+			FOR UPDATE OF webhook_deliveries SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns + `, webhooks.url, webhooks.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*PendingDelivery{}
+
+	for rows.Next() {
+		delivery := PendingDelivery{WebhookDelivery: &WebhookDelivery{}}
+		err := rows.Scan(append(scanDelivery(delivery.WebhookDelivery), &delivery.URL, &delivery.Secret)...)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt saves the status and the outcome of the latest attempt of a
+// delivery.
+func (m WebhookDeliveryModel) RecordAttempt(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = $5, response_status = $6,
+			last_error = $7
+		WHERE id = $1`
+
+	args := []interface{}{
+		delivery.ID,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastAttemptAt,
+		delivery.ResponseStatus,
+		delivery.LastError,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetAllForWebhook returns a slice of the deliveries of a specific webhook,
+// optionally only those with the given status.
+func (m WebhookDeliveryModel) GetAllForWebhook(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND (status = $2 OR $2 = '')
+		ORDER BY %s, id ASC
+		LIMIT $3 OFFSET $4`, deliveryColumns, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := rows.Scan(append([]interface{}{&totalRecords}, scanDelivery(&delivery)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters, "")
+
+	return deliveries, metadata, nil
+}
+
+// Redeliver records a new pending delivery of the event and payload of an
+// earlier delivery to the same webhook, whatever the status of the earlier one.
+func (m WebhookDeliveryModel) Redeliver(webhookID, id int64) (*WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, redelivery_of)
+		SELECT webhook_id, event, payload, id
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND id = $2
+		RETURNING ` + deliveryColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var delivery WebhookDelivery
+	err := m.DB.QueryRowContext(ctx, query, webhookID, id).Scan(scanDelivery(&delivery)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &delivery, nil
+}
@@ -0,0 +1,81 @@
+// Package imaging scales images, using only the standard library.
+package imaging
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Fit returns a copy of the image scaled down to fit within the given width and
+// height, preserving its aspect ratio. Images which already fit are copied
+// without scaling. Each pixel of the result is the average of the source pixels
+// that it covers.
+func Fit(src image.Image, maxWidth, maxHeight int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	// Convert the source to RGBA first, which is much faster than reading the
+	// pixels one by one through the image.Image interface.
+	rgba := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	width, height := srcWidth, srcHeight
+	if width > maxWidth {
+		width, height = maxWidth, height*maxWidth/width
+	}
+	if height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	if width == srcWidth && height == srcHeight {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, srcHeight, height)
+
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, srcWidth, width)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			p := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			p[0] = uint8(r / n)
+			p[1] = uint8(g / n)
+			p[2] = uint8(b / n)
+			p[3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// span returns the range of source pixels covered by pixel i of the result,
+// along an axis that is scaled from n to m pixels. The range covers at least one
+// pixel.
+func span(i, n, m int) (int, int) {
+	start, end := i*n/m, (i+1)*n/m
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
@@ -0,0 +1,413 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON documents.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned for patch documents that are malformed.
+	ErrInvalidPatch = errors.New("invalid patch document")
+	// ErrTestFailed is returned when a JSON Patch test operation doesn't match.
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// Merge applies a JSON Merge Patch to the document and returns the result.
+// Members of the patch with a null value are removed from the document.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	var p interface{}
+	err = json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+// merge implements the MergePatch function from RFC 7396, section 2.
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = merge(t[key], value)
+		}
+	}
+
+	return t
+}
+
+// operation holds a single JSON Patch operation. The value is kept as a raw
+// message so that an explicit null can be told apart from a missing value.
+type operation struct {
+	Op    string
+	Path  string
+	From  string
+	Value json.RawMessage
+}
+
+// Apply applies a JSON Patch to the document and returns the result. The
+// operations are applied in order, and the patch is aborted at the first
+// operation that fails.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := parseOperations(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// parseOperations decodes a JSON Patch document into its operations, checking
+// that each operation has the members required by its op.
+func parseOperations(patch []byte) ([]operation, error) {
+	var raw []map[string]json.RawMessage
+	err := json.Unmarshal(patch, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: must be an array of operation objects", ErrInvalidPatch)
+	}
+
+	ops := make([]operation, len(raw))
+	for i, members := range raw {
+		var op operation
+
+		for _, key := range []string{"op", "path", "from"} {
+			value, ok := members[key]
+			if !ok {
+				continue
+			}
+			var s string
+			if json.Unmarshal(value, &s) != nil {
+				return nil, fmt.Errorf("%w: operation %d member %q must be a string", ErrInvalidPatch, i, key)
+			}
+			switch key {
+			case "op":
+				op.Op = s
+			case "path":
+				op.Path = s
+			case "from":
+				op.From = s
+			}
+		}
+
+		if _, ok := members["path"]; !ok {
+			return nil, fmt.Errorf("%w: operation %d is missing the \"path\" member", ErrInvalidPatch, i)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			value, ok := members["value"]
+			if !ok {
+				return nil, fmt.Errorf("%w: operation %d is missing the \"value\" member", ErrInvalidPatch, i)
+			}
+			op.Value = value
+		case "move", "copy":
+			if _, ok := members["from"]; !ok {
+				return nil, fmt.Errorf("%w: operation %d is missing the \"from\" member", ErrInvalidPatch, i)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unknown op %q", ErrInvalidPatch, i, op.Op)
+		}
+
+		ops[i] = op
+	}
+
+	return ops, nil
+}
+
+// apply applies the operation to the document and returns the result.
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		// Replacing the whole document can't be done by removing it first.
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, _, err = remove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("%w: cannot move %q into one of its children", ErrInvalidPatch, op.From)
+		}
+		// Moving a value to where it is leaves the document unchanged, as long as
+		// the value exists.
+		if op.Path == op.From {
+			_, err := get(doc, from)
+			return doc, err
+		}
+		doc, value, err := remove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, deepCopy(value))
+
+	case "test":
+		expected, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(value, expected) {
+			return nil, fmt.Errorf("%w: value at %q doesn't match", ErrTestFailed, op.Path)
+		}
+		return doc, nil
+	}
+
+	panic("unknown patch operation: " + op.Op)
+}
+
+// value decodes the value member of the operation.
+func (op operation) value() (interface{}, error) {
+	var value interface{}
+	err := json.Unmarshal(op.Value, &value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid value: %s", ErrInvalidPatch, err)
+	}
+	return value, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with \"/\"", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index reference token. The "-" token refers to
+// the position after the last element, and is only valid when allowEnd is set.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d is out of bounds", i)
+	}
+	return i, nil
+}
+
+// get returns the value at the path.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("cannot reference %q in a scalar value", token)
+		}
+	}
+	return doc, nil
+}
+
+// update calls fn with the container holding the last token of the path, and
+// replaces that container with the one returned by fn.
+func update(doc interface{}, path []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		container[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(container), false)
+		container[i] = child
+	}
+	return doc, nil
+}
+
+// add adds the value at the path, inserting it into arrays and replacing any
+// existing object member.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c), true)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to a scalar value", token)
+		}
+	})
+}
+
+// remove removes the value at the path, and returns the document together with
+// the removed value.
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+
+	var removed interface{}
+	doc, err := update(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			value, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			removed = value
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = c[i]
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from a scalar value", token)
+		}
+	})
+	return doc, removed, err
+}
+
+// deepCopy returns a copy of a decoded JSON value that shares no maps or slices
+// with the original.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			c[key] = deepCopy(elem)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, elem := range v {
+			c[i] = deepCopy(elem)
+		}
+		return c
+	default:
+		return v
+	}
+}
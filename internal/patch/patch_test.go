@@ -0,0 +1,383 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// equalJSON reports whether two JSON documents hold the same value.
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("%s: %v", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("%s: %v", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// The cases from RFC 7396, appendix A.
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("Merge(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if !equalJSON(t, got, []byte(tt.want)) {
+			t.Errorf("Merge(%s, %s) = %s; want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergeInvalidPatch(t *testing.T) {
+	_, err := Merge([]byte(`{"a":"b"}`), []byte(`{"a":`))
+	if !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("got error %v; want %v", err, ErrInvalidPatch)
+	}
+}
+
+func TestApply(t *testing.T) {
+	// A case without a wanted document must fail, with the error if one is given.
+	tests := []struct {
+		name, doc, patch, want string
+		err                    error
+	}{
+		// RFC 6902, appendix A.
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "A.9 testing a value: error",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+		},
+		{
+			name:  "A.13 invalid JSON patch document",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+
+		// Operations on the whole document.
+		{
+			name:  "add the root",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"","value":{"baz":"qux"}}]`,
+			want:  `{"baz":"qux"}`,
+		},
+		{
+			name:  "replace the root",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"replace","path":"","value":["baz"]}]`,
+			want:  `["baz"]`,
+		},
+		{
+			name:  "replace the root with null",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"replace","path":"","value":null}]`,
+			want:  `null`,
+		},
+		{
+			name:  "remove the root",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"remove","path":""}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "test the root",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"test","path":"","value":{"foo":["bar"]}}]`,
+			want:  `{"foo":["bar"]}`,
+		},
+		{
+			name:  "copy the root",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"copy","from":"","path":"/baz"},{"op":"add","path":"/baz/foo","value":"qux"}]`,
+			want:  `{"foo":"bar","baz":{"foo":"qux"}}`,
+		},
+		{
+			name:  "move the root to itself",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"move","from":"","path":""}]`,
+			want:  `{"foo":"bar"}`,
+		},
+
+		// The "-" index.
+		{
+			name:  "add to the end of the root array",
+			doc:   `[1,2]`,
+			patch: `[{"op":"add","path":"/-","value":3}]`,
+			want:  `[1,2,3]`,
+		},
+		{
+			name:  "move to the end of an array",
+			doc:   `{"foo":[1,2,3]}`,
+			patch: `[{"op":"move","from":"/foo/0","path":"/foo/-"}]`,
+			want:  `{"foo":[2,3,1]}`,
+		},
+		{
+			name:  "remove the end of an array",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"remove","path":"/foo/-"}]`,
+		},
+		{
+			name:  "replace the end of an array",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"replace","path":"/foo/-","value":3}]`,
+		},
+		{
+			name:  "test the end of an array",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"test","path":"/foo/-","value":2}]`,
+		},
+		{
+			name:  "copy from the end of an array",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"copy","from":"/foo/-","path":"/bar"}]`,
+		},
+		{
+			name:  "add past the end of an array",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"add","path":"/foo/3","value":3}]`,
+		},
+		{
+			name:  "add at an index with a leading zero",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"add","path":"/foo/01","value":3}]`,
+		},
+
+		// Moving a value into its own child.
+		{
+			name:  "move into a child",
+			doc:   `{"foo":{"bar":1}}`,
+			patch: `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "move the root into a child",
+			doc:   `{"foo":{"bar":1}}`,
+			patch: `[{"op":"move","from":"","path":"/foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "move to a sibling with the same prefix",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"move","from":"/foo","path":"/foobar"}]`,
+			want:  `{"foobar":1}`,
+		},
+		{
+			name:  "move to itself",
+			doc:   `{"foo":[1,2]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/1"}]`,
+			want:  `{"foo":[1,2]}`,
+		},
+		{
+			name:  "move a missing value to itself",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"move","from":"/bar","path":"/bar"}]`,
+		},
+
+		// Failing tests, which abort the whole patch.
+		{
+			name:  "test after a change",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"replace","path":"/foo","value":2},{"op":"test","path":"/foo","value":1}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "test an array against a longer one",
+			doc:   `{"foo":[1]}`,
+			patch: `[{"op":"test","path":"/foo","value":[1,2]}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "test a null against a missing member",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"test","path":"/bar","value":null}]`,
+		},
+
+		// Malformed operations.
+		{
+			name:  "add without a value",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"add","path":"/bar"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "replace without a value",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"replace","path":"/foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "test without a value",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"test","path":"/foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "replace a missing member",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"replace","path":"/bar","value":2}]`,
+		},
+		{
+			name:  "path without a leading slash",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"remove","path":"foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "unknown op",
+			doc:   `{"foo":1}`,
+			patch: `[{"op":"increment","path":"/foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "not an array",
+			doc:   `{"foo":1}`,
+			patch: `{"op":"remove","path":"/foo"}`,
+			err:   ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+			if tt.want == "" {
+				switch {
+				case err == nil:
+					t.Errorf("got %s; want an error", got)
+				case tt.err != nil && !errors.Is(err, tt.err):
+					t.Errorf("got error %v; want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalJSON(t, got, []byte(tt.want)) {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationInvalidValue(t *testing.T) {
+	// Values are checked when the patch is decoded, but the operations don't rely
+	// on it.
+	for _, op := range []string{"add", "replace", "test"} {
+		o := operation{Op: op, Path: "/foo", Value: json.RawMessage(`{`)}
+		_, err := o.apply(map[string]interface{}{"foo": 1.0})
+		if !errors.Is(err, ErrInvalidPatch) {
+			t.Errorf("%s: got error %v; want %v", op, err, ErrInvalidPatch)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package storage stores binary objects, such as uploaded images, under
+// slash-separated keys.
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when no object is stored under a key.
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute, or that contain
+	// "." or ".." elements.
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Storage is implemented by object storage backends.
+type Storage interface {
+	// Put stores the contents of r under the key, replacing any existing object.
+	Put(key string, r io.Reader) error
+	// Open returns the object stored under the key, or ErrNotFound.
+	Open(key string) (io.ReadSeekCloser, error)
+	// Delete removes the object stored under the key. Deleting a missing object
+	// is not an error.
+	Delete(key string) error
+	// DeleteAll removes all objects with keys under the prefix, which is a
+	// slash-separated directory such as "movies/1".
+	DeleteAll(prefix string) error
+}
+
+// Local stores objects as files in a directory on the local filesystem.
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a Local storage backend rooted at the directory, creating the
+// directory if it doesn't exist.
+func NewLocal(dir string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+// path returns the filesystem path of the key.
+func (l *Local) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key {
+		return "", ErrInvalidKey
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "." || elem == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(key string, r io.Reader) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file which is renamed into place, so that readers
+	// never see a partially written object.
+	f, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+func (l *Local) Open(key string) (io.ReadSeekCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (l *Local) Delete(key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) DeleteAll(prefix string) error {
+	name, err := l.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(name)
+}
@@ -0,0 +1,120 @@
+// Package webhook sends signed event notifications to HTTP endpoints.
+//
+// Each notification is a POST request with a JSON body. The body is signed with
+// HMAC-SHA256, using the secret shared with the receiver, over the timestamp in
+// the Timestamp header and the body joined by a period. The signature is sent in
+// the Signature header as "sha256=" followed by the hex-encoded MAC, and can be
+// checked by the receiver with Verify.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Request headers sent with each notification.
+const (
+	EventHeader     = "X-Greenlight-Event"
+	DeliveryHeader  = "X-Greenlight-Delivery"
+	TimestampHeader = "X-Greenlight-Timestamp"
+	SignatureHeader = "X-Greenlight-Signature"
+)
+
+// Sign returns the signature of a body sent at the Unix timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature and timestamp headers of a notification
+// match its body.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body)))
+}
+
+// Backoff returns the delay before the next attempt of a notification after the
+// given number of failed attempts. The delay starts at 30 seconds and doubles
+// with each attempt, up to an hour.
+func Backoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// Notification holds an event to send to an endpoint.
+type Notification struct {
+	URL        string
+	Secret     string
+	Event      string
+	DeliveryID int64
+	Body       []byte
+}
+
+// Client sends notifications. Redirects are not followed, so a redirect response
+// counts as a failure.
+type Client struct {
+	client *http.Client
+}
+
+func New(timeout time.Duration) Client {
+	return Client{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send posts a notification, and returns the status code of the response. Any
+// response status other than 2xx is returned along with an error.
+func (c Client) Send(n Notification) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(n.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhook")
+	req.Header.Set(EventHeader, n.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(n.DeliveryID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(n.Secret, timestamp, n.Body))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Drain some of the body, so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
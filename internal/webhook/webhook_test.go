@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendSignsNotification(t *testing.T) {
+	const secret = "0123456789abcdef"
+	body := []byte(`{"id":1,"event":"movie.created"}`)
+
+	var (
+		mu       sync.Mutex
+		verified bool
+		header   http.Header
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		header = r.Header.Clone()
+		verified = Verify(secret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), received)
+	}))
+	defer ts.Close()
+
+	status, err := New(time.Second).Send(Notification{
+		URL:        ts.URL,
+		Secret:     secret,
+		Event:      "movie.created",
+		DeliveryID: 42,
+		Body:       body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("got status %d; want %d", status, http.StatusOK)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !verified {
+		t.Error("signature doesn't match the body")
+	}
+	if got := header.Get(EventHeader); got != "movie.created" {
+		t.Errorf("got event header %q; want %q", got, "movie.created")
+	}
+	if got := header.Get(DeliveryHeader); got != "42" {
+		t.Errorf("got delivery header %q; want %q", got, "42")
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q; want %q", got, "application/json")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const secret = "0123456789abcdef"
+	body := []byte(`{"id":1}`)
+	timestamp := time.Now().Unix()
+	signature := Sign(secret, timestamp, body)
+	ts := strconv.FormatInt(timestamp, 10)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		want      bool
+	}{
+		{"Valid", secret, ts, signature, body, true},
+		{"WrongSecret", "fedcba9876543210", ts, signature, body, false},
+		{"WrongTimestamp", secret, strconv.FormatInt(timestamp+1, 10), signature, body, false},
+		{"InvalidTimestamp", secret, "now", signature, body, false},
+		{"ChangedBody", secret, ts, signature, []byte(`{"id":2}`), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.timestamp, tt.signature, tt.body); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("redirect was followed")
+	}))
+	defer target.Close()
+
+	ts := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer ts.Close()
+
+	status, err := New(time.Second).Send(Notification{URL: ts.URL, Secret: "0123456789abcdef", Event: "movie.deleted", Body: []byte(`{}`)})
+	if err == nil {
+		t.Fatal("got no error for a redirect response")
+	}
+	if status != http.StatusTemporaryRedirect {
+		t.Errorf("got status %d; want %d", status, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s; want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
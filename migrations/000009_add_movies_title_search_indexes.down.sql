@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_title_english_idx;
+DROP INDEX IF EXISTS movies_title_swedish_idx;
+DROP INDEX IF EXISTS movies_title_norwegian_idx;
+DROP INDEX IF EXISTS movies_title_danish_idx;
+DROP INDEX IF EXISTS movies_title_finnish_idx;
@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS movies_title_english_idx ON movies USING GIN (to_tsvector('english', title));
+CREATE INDEX IF NOT EXISTS movies_title_swedish_idx ON movies USING GIN (to_tsvector('swedish', title));
+CREATE INDEX IF NOT EXISTS movies_title_norwegian_idx ON movies USING GIN (to_tsvector('norwegian', title));
+CREATE INDEX IF NOT EXISTS movies_title_danish_idx ON movies USING GIN (to_tsvector('danish', title));
+CREATE INDEX IF NOT EXISTS movies_title_finnish_idx ON movies USING GIN (to_tsvector('finnish', title));
@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code IN ('reviews:write', 'reviews:moderate');
+DROP TABLE IF EXISTS reviews;
+DROP TABLE IF EXISTS ratings;
+DROP INDEX IF EXISTS movies_average_rating_idx;
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS average_rating,
+    DROP COLUMN IF EXISTS ratings_count;
@@ -0,0 +1,41 @@
+-- The rating aggregates are kept on the movies table, so that they can be used
+-- for sorting without joining the ratings.
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS average_rating real    NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS ratings_count  integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating);
+
+CREATE TABLE IF NOT EXISTS ratings
+(
+    movie_id   bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    rating     smallint                    NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    PRIMARY KEY (movie_id, user_id)
+);
+
+-- A review is always accompanied by a rating, and is removed together with it.
+CREATE TABLE IF NOT EXISTS reviews
+(
+    movie_id   bigint                      NOT NULL,
+    user_id    bigint                      NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    body       text                        NOT NULL,
+    PRIMARY KEY (movie_id, user_id),
+    FOREIGN KEY (movie_id, user_id) REFERENCES ratings ON DELETE CASCADE
+);
+
+INSERT INTO permissions (code)
+VALUES ('reviews:write'),
+       ('reviews:moderate');
+
+-- Existing users who can read movies may also rate them, like new users.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, (SELECT id FROM permissions WHERE code = 'reviews:write')
+FROM users_permissions
+    INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+WHERE permissions.code = 'movies:read'
+ON CONFLICT DO NOTHING;
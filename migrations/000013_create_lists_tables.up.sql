@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS lists
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id     bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    name        text                        NOT NULL,
+    description text                        NOT NULL DEFAULT '',
+    public      boolean                     NOT NULL DEFAULT false,
+    watchlist   boolean                     NOT NULL DEFAULT false,
+    version     integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS lists_user_id_idx ON lists (user_id);
+
+-- Each user has at most one watchlist.
+CREATE UNIQUE INDEX IF NOT EXISTS lists_user_id_watchlist_idx ON lists (user_id) WHERE watchlist;
+
+CREATE TABLE IF NOT EXISTS list_entries
+(
+    list_id    bigint                      NOT NULL REFERENCES lists ON DELETE CASCADE,
+    movie_id   bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    position   integer                     NOT NULL,
+    note       text                        NOT NULL DEFAULT '',
+    PRIMARY KEY (list_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS list_entries_movie_id_idx ON list_entries (movie_id);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS people
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name       text                        NOT NULL,
+    birth_year integer,
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS credits
+(
+    id            bigserial PRIMARY KEY,
+    movie_id      bigint  NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id     bigint  NOT NULL REFERENCES people ON DELETE CASCADE,
+    role          text    NOT NULL CHECK (role IN ('director', 'writer', 'actor')),
+    character     text    NOT NULL DEFAULT '',
+    billing_order integer NOT NULL DEFAULT 0,
+    UNIQUE (movie_id, person_id, role, character)
+);
+
+CREATE INDEX IF NOT EXISTS credits_person_id_idx ON credits (person_id);
@@ -0,0 +1,3 @@
+-- The original spellings of remapped genres can't be restored.
+DELETE FROM permissions WHERE code = 'genres:write';
+DROP TABLE IF EXISTS genres;
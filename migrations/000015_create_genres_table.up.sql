@@ -0,0 +1,98 @@
+CREATE TABLE IF NOT EXISTS genres
+(
+    slug       text PRIMARY KEY CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name       text                        NOT NULL,
+    synonyms   text[]                      NOT NULL DEFAULT '{}',
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+INSERT INTO permissions (code)
+VALUES ('genres:write');
+
+-- Seed the vocabulary with common genres. Synonyms are stored in the same
+-- normalized form as slugs: lowercase, with runs of other characters than
+-- a-z and 0-9 replaced by a single hyphen.
+INSERT INTO genres (slug, name, synonyms)
+VALUES ('action', 'Action', '{}'),
+       ('adventure', 'Adventure', '{}'),
+       ('animation', 'Animation', '{animated}'),
+       ('biography', 'Biography', '{biopic}'),
+       ('comedy', 'Comedy', '{}'),
+       ('crime', 'Crime', '{}'),
+       ('documentary', 'Documentary', '{doc}'),
+       ('drama', 'Drama', '{}'),
+       ('family', 'Family', '{}'),
+       ('fantasy', 'Fantasy', '{}'),
+       ('history', 'History', '{historical}'),
+       ('horror', 'Horror', '{}'),
+       ('musical', 'Musical', '{}'),
+       ('mystery', 'Mystery', '{}'),
+       ('romance', 'Romance', '{romantic}'),
+       ('science-fiction', 'Science Fiction', '{sci-fi,scifi,sf}'),
+       ('thriller', 'Thriller', '{}'),
+       ('war', 'War', '{}'),
+       ('western', 'Western', '{}')
+ON CONFLICT DO NOTHING;
+
+-- genre_key returns the key of a genre name, as GenreKey does: its normalized
+-- form, or for names without any a-z or 0-9 characters, such as non-ASCII names,
+-- a slug derived from a hash of the name. Only blank names have an empty key.
+CREATE FUNCTION pg_temp.genre_key(genre text) RETURNS text
+    LANGUAGE sql
+    IMMUTABLE AS
+$$
+SELECT CASE
+           WHEN k.key <> '' THEN k.key
+           WHEN trim(genre) <> '' THEN 'genre-' || left(md5(lower(trim(genre))), 8)
+           ELSE ''
+           END
+FROM (SELECT trim(BOTH '-' FROM regexp_replace(lower(genre), '[^a-z0-9]+', '-', 'g')) AS key) AS k
+$$;
+
+-- Add the remaining genres in use, named after one of their current spellings.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (key) key, trim(genre)
+FROM (SELECT genre, pg_temp.genre_key(genre) AS key
+      FROM movies
+               CROSS JOIN LATERAL unnest(genres) AS g(genre)
+      UNION ALL
+      SELECT genre, pg_temp.genre_key(genre) AS key
+      FROM movie_revisions
+               CROSS JOIN LATERAL unnest(genres) AS g(genre)) AS used
+WHERE key <> ''
+  AND NOT EXISTS (SELECT 1 FROM genres WHERE genres.slug = key OR key = ANY (genres.synonyms))
+ORDER BY key, genre
+ON CONFLICT DO NOTHING;
+
+-- Remap the genres of movies and their revisions to the canonical slugs, keeping
+-- the original order and dropping duplicates. Movie versions are left unchanged,
+-- as the genres are only spelled differently. Every genre has a slug by now, so
+-- only blank genres are dropped.
+UPDATE movies
+SET genres = ARRAY(SELECT genres.slug
+                   FROM unnest(movies.genres) WITH ORDINALITY AS g(genre, position)
+                            INNER JOIN genres ON genres.slug = pg_temp.genre_key(g.genre)
+                       OR pg_temp.genre_key(g.genre) = ANY (genres.synonyms)
+                   GROUP BY genres.slug
+                   ORDER BY min(g.position));
+
+UPDATE movie_revisions
+SET genres = ARRAY(SELECT genres.slug
+                   FROM unnest(movie_revisions.genres) WITH ORDINALITY AS g(genre, position)
+                            INNER JOIN genres ON genres.slug = pg_temp.genre_key(g.genre)
+                       OR pg_temp.genre_key(g.genre) = ANY (genres.synonyms)
+                   GROUP BY genres.slug
+                   ORDER BY min(g.position));
+
+-- Movies must keep at least one genre, which genres_length_check doesn't catch
+-- for empty arrays, so fail the migration rather than leave any without one.
+DO
+$$
+    BEGIN
+        IF EXISTS (SELECT 1 FROM movies WHERE cardinality(genres) = 0) THEN
+            RAISE EXCEPTION 'movies without any genres after remapping them: %',
+                (SELECT string_agg(id::text, ', ' ORDER BY id) FROM movies WHERE cardinality(genres) = 0);
+        END IF;
+    END
+$$;
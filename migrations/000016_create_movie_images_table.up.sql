@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS movie_images
+(
+    movie_id     bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    kind         text                        NOT NULL CHECK (kind IN ('poster', 'backdrop')),
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    content_type text                        NOT NULL,
+    width        integer                     NOT NULL,
+    height       integer                     NOT NULL,
+    size         bigint                      NOT NULL,
+    checksum     text                        NOT NULL,
+    PRIMARY KEY (movie_id, kind)
+);
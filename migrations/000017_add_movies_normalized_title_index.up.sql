@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS movies_normalized_title_year_idx ON movies ((lower(regexp_replace(title, '[^[:alnum:]]+', '', 'g'))), year);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS movie_titles
+(
+    movie_id bigint  NOT NULL REFERENCES movies ON DELETE CASCADE,
+    language text    NOT NULL,
+    title    text    NOT NULL,
+    original boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (movie_id, language)
+);
+
+-- A movie has at most one original title.
+CREATE UNIQUE INDEX IF NOT EXISTS movie_titles_original_idx ON movie_titles (movie_id) WHERE original;
+
+-- The localized titles are searched in the same ways as the movie titles.
+CREATE INDEX IF NOT EXISTS movie_titles_title_idx ON movie_titles USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_english_idx ON movie_titles USING GIN (to_tsvector('english', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_swedish_idx ON movie_titles USING GIN (to_tsvector('swedish', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_norwegian_idx ON movie_titles USING GIN (to_tsvector('norwegian', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_danish_idx ON movie_titles USING GIN (to_tsvector('danish', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_finnish_idx ON movie_titles USING GIN (to_tsvector('finnish', title));
+CREATE INDEX IF NOT EXISTS movie_titles_title_trgm_idx ON movie_titles USING GIN (title gin_trgm_ops);
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS movies_record_change ON movies;
+DROP FUNCTION IF EXISTS record_movie_change();
+DROP TABLE IF EXISTS movie_changes;
+DROP TRIGGER IF EXISTS movies_set_updated_at ON movies;
+DROP FUNCTION IF EXISTS set_movies_updated_at();
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,81 @@
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+-- Existing movies were last updated by their latest revision.
+UPDATE movies
+SET updated_at = COALESCE((SELECT max(created_at) FROM movie_revisions WHERE movie_id = movies.id), created_at);
+
+CREATE OR REPLACE FUNCTION set_movies_updated_at() RETURNS trigger AS
+$$
+BEGIN
+    NEW.updated_at := NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movies_set_updated_at ON movies;
+CREATE TRIGGER movies_set_updated_at
+    BEFORE UPDATE ON movies
+    FOR EACH ROW EXECUTE FUNCTION set_movies_updated_at();
+
+-- The change feed records every change to a movie that is visible to clients.
+-- Deletions are kept as tombstones, so there is no foreign key to the movies.
+CREATE TABLE IF NOT EXISTS movie_changes
+(
+    seq        bigserial PRIMARY KEY,
+    movie_id   bigint                      NOT NULL,
+    kind       text                        NOT NULL CHECK (kind IN ('created', 'updated', 'deleted')),
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Clients syncing from the start of the feed first get all existing movies.
+INSERT INTO movie_changes (movie_id, kind, changed_at)
+SELECT id, 'created', created_at
+FROM movies
+WHERE deleted_at IS NULL
+ORDER BY id;
+
+-- Moving a movie to the trash deletes it, and restoring it creates it again, as
+-- far as clients are concerned. Changes to trashed movies aren't recorded.
+CREATE OR REPLACE FUNCTION record_movie_change() RETURNS trigger AS
+$$
+DECLARE
+    changed_id  bigint;
+    change_kind text;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        changed_id := NEW.id;
+        change_kind := 'created';
+    ELSIF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        changed_id := OLD.id;
+        change_kind := 'deleted';
+    ELSE
+        changed_id := NEW.id;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            change_kind := 'deleted';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            change_kind := 'created';
+        ELSIF NEW.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        ELSE
+            change_kind := 'updated';
+        END IF;
+    END IF;
+
+    -- The trigger is deferred until the transaction commits, and the lock is held
+    -- until the commit is complete, so the changes are numbered in commit order.
+    PERFORM pg_advisory_xact_lock(hashtext('movie_changes'));
+
+    INSERT INTO movie_changes (movie_id, kind) VALUES (changed_id, change_kind);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movies_record_change ON movies;
+CREATE CONSTRAINT TRIGGER movies_record_change
+    AFTER INSERT OR UPDATE OR DELETE ON movies
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION record_movie_change();
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    url        text                        NOT NULL,
+    events     text[]                      NOT NULL,
+    secret     text                        NOT NULL,
+    active     boolean                     NOT NULL DEFAULT true,
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+-- Each event sent to a webhook is recorded as a delivery, which is retried until
+-- it succeeds or runs out of attempts. A redelivery is a new delivery with the
+-- same event and payload as an earlier one.
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              bigserial PRIMARY KEY,
+    webhook_id      bigint                      NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    created_at      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    event           text                        NOT NULL,
+    payload         jsonb                       NOT NULL,
+    status          text                        NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts        integer                     NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone DEFAULT NOW(),
+    last_attempt_at timestamp with time zone,
+    response_status integer,
+    last_error      text,
+    redelivery_of   bigint REFERENCES webhook_deliveries ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_next_attempt_at_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+INSERT INTO permissions (code)
+VALUES ('webhooks:admin');
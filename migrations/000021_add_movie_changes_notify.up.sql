@@ -0,0 +1,18 @@
+-- Notify listeners of each change to a movie as it's recorded. Notifications are
+-- delivered when the transaction commits, in commit order.
+CREATE OR REPLACE FUNCTION notify_movie_change() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('movie_changes', json_build_object(
+            'seq', NEW.seq,
+            'movie_id', NEW.movie_id,
+            'kind', NEW.kind,
+            'changed_at', NEW.changed_at)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movie_changes_notify ON movie_changes;
+CREATE TRIGGER movie_changes_notify
+    AFTER INSERT ON movie_changes
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_change();
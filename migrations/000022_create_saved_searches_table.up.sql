@@ -0,0 +1,27 @@
+-- A saved search holds the parameters of a movie listing. Its owner is notified
+-- of the movies created since the last run of the matcher, which are those with
+-- changes in the change feed following last_seq.
+CREATE TABLE IF NOT EXISTS saved_searches
+(
+    id                bigserial PRIMARY KEY,
+    created_at        timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id           bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    name              text                        NOT NULL,
+    query             jsonb                       NOT NULL,
+    notify            boolean                     NOT NULL DEFAULT true,
+    last_seq          bigint                      NOT NULL DEFAULT 0,
+    version           integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS saved_searches_user_id_idx ON saved_searches (user_id);
+
+-- Each digest links to a new unsubscribe token, which is stored as a SHA-256
+-- hash like the other tokens, and stays valid for a while after it's been sent.
+CREATE TABLE IF NOT EXISTS saved_search_tokens
+(
+    hash            bytea PRIMARY KEY,
+    saved_search_id bigint                      NOT NULL REFERENCES saved_searches ON DELETE CASCADE,
+    expiry          timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS saved_search_tokens_saved_search_id_idx ON saved_search_tokens (saved_search_id);
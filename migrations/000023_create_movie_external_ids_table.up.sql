@@ -0,0 +1,10 @@
+-- An external identifier belongs to at most one movie, including movies in the
+-- trash, so that restoring a movie never conflicts with another one.
+CREATE TABLE IF NOT EXISTS movie_external_ids
+(
+    movie_id  bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    namespace text   NOT NULL CHECK (namespace IN ('imdb', 'tmdb', 'wikidata')),
+    value     text   NOT NULL,
+    PRIMARY KEY (movie_id, namespace),
+    UNIQUE (namespace, value)
+);
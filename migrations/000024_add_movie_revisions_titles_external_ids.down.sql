@@ -0,0 +1,3 @@
+ALTER TABLE movie_revisions
+    DROP COLUMN IF EXISTS titles,
+    DROP COLUMN IF EXISTS external_ids;
@@ -0,0 +1,14 @@
+-- Revisions record the localized titles and external identifiers of the movie
+-- as well. They are NULL in the revisions made before they were recorded.
+ALTER TABLE movie_revisions
+    ADD COLUMN IF NOT EXISTS titles       jsonb,
+    ADD COLUMN IF NOT EXISTS external_ids jsonb;
+
+-- The latest revision of each movie records its current titles and identifiers,
+-- which haven't changed since it was made.
+UPDATE movie_revisions
+SET titles       = coalesce((SELECT jsonb_agg(jsonb_build_object('language', language, 'title', title, 'original', original) ORDER BY language)
+                             FROM movie_titles WHERE movie_id = movie_revisions.movie_id), '[]'),
+    external_ids = coalesce((SELECT jsonb_object_agg(namespace, value)
+                             FROM movie_external_ids WHERE movie_id = movie_revisions.movie_id), '{}')
+WHERE version = (SELECT max(version) FROM movie_revisions AS latest WHERE latest.movie_id = movie_revisions.movie_id);